@@ -35,18 +35,18 @@ func main() {
 
 	// Update Session Limit setting
 	r.POST("/session-limit", func(c *gin.Context) {
-		var config SessionLimitConfig
+		var config SessionLimitRequest
 		if err := c.ShouldBindJSON(&config); err != nil {
 			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
 
-		redisClient.Set(c, "session_limit", config.Limit, 0)
+		client.Set(c, "session_limit", config.Limit, 0)
 		c.JSON(200, gin.H{"message": "Session limit updated = " + strconv.Itoa(config.Limit)})
 	})
 
 	r.GET("/session-limit", func(c *gin.Context) {
-		limit, err := redisClient.Get(c, "session_limit").Int()
+		limit, err := client.Get(c, "session_limit").Int()
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
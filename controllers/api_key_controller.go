@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type IAPIKeyController interface {
+	Create(c *gin.Context)
+	FindAll(c *gin.Context)
+	Revoke(c *gin.Context)
+}
+
+type APIKeyController struct {
+	apiKeyService services.IAPIKeyService
+}
+
+func NewAPIKeyController(apiKeyService services.IAPIKeyService) IAPIKeyController {
+	return &APIKeyController{apiKeyService: apiKeyService}
+}
+
+func (c *APIKeyController) Create(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var input dto.CreateAPIKeyInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := c.apiKeyService.Create(user.(*models.User).ID, input)
+	if err != nil {
+		log.Println("Create API key failed : ", err)
+		respondAPIKeyError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, key)
+}
+
+func (c *APIKeyController) FindAll(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	keys, err := c.apiKeyService.FindAll(user.(*models.User).ID)
+	if err != nil {
+		log.Println("Find API keys failed : ", err)
+		respondAPIKeyError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, keys)
+}
+
+func (c *APIKeyController) Revoke(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := c.apiKeyService.Revoke(user.(*models.User).ID, uint(id)); err != nil {
+		log.Println("Revoke API key failed : ", err)
+		respondAPIKeyError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+func respondAPIKeyError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, services.ErrAPIKeyExpiryPassed):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTooManyAPIKeys):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,10 +1,16 @@
 package controllers
 
 import (
+	"errors"
 	"gin-freemarket/dto"
+	"gin-freemarket/models"
 	"gin-freemarket/services"
+	"gin-freemarket/utils/oidc"
+	"gin-freemarket/utils/passwords"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,8 +18,26 @@ import (
 type IAuthController interface {
 	Register(c *gin.Context)
 	Login(c *gin.Context)
+	LoginMFA(c *gin.Context)
+	BeginMFAEnrollment(c *gin.Context)
+	ConfirmMFAEnrollment(c *gin.Context)
+	DisableMFA(c *gin.Context)
+	Refresh(c *gin.Context)
+	Logout(c *gin.Context)
+	LogoutAll(c *gin.Context)
+	Sessions(c *gin.Context)
+	JWKS(c *gin.Context)
+	VerifyEmail(c *gin.Context)
+	ResendVerification(c *gin.Context)
+	RequestPasswordReset(c *gin.Context)
+	ConfirmPasswordReset(c *gin.Context)
+	OIDCStart(c *gin.Context)
+	OIDCCallback(c *gin.Context)
 }
 
+// oidcStateCookie holds the signed login state between the start and the callback of an OIDC login
+const oidcStateCookie = "oidc_state"
+
 type AuthController struct {
 	authService services.IAuthService
 }
@@ -32,6 +56,10 @@ func (c *AuthController) Register(ctx *gin.Context) {
 
 	if err := c.authService.Register(request.Email, request.Password); err != nil {
 		log.Println("Register failed : ", err)
+		if isPasswordPolicyError(err) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -47,12 +75,381 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	token, err := c.authService.Login(request.Email, request.Password)
+	result, err := c.authService.Login(request.Email, request.Password, ctx.ClientIP())
 	if err != nil {
 		log.Println("Login failed : ", err)
+		respondLoginError(ctx, err)
+		return
+	}
+
+	respondLoginResult(ctx, result)
+}
+
+func respondLoginError(ctx *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.As(err, &throttled):
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+	}
+}
+
+// respondLoginResult answers with the tokens, or with the MFA challenge for users with 2FA.
+func respondLoginResult(ctx *gin.Context, result *services.LoginResult) {
+	if result.MFAToken != "" {
+		ctx.JSON(http.StatusOK, dto.MFAChallengeResponse{
+			Message:     "Enter the code from your authenticator app",
+			MFARequired: true,
+			MFAToken:    result.MFAToken,
+		})
+		return
+	}
+
+	response := toTokenResponse(result.Tokens)
+	response.Message = "Login success"
+	ctx.JSON(http.StatusOK, response)
+}
+
+// LoginMFA is the second step of a login with 2FA enabled.
+func (c *AuthController) LoginMFA(ctx *gin.Context) {
+	var request dto.MFALoginRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, err := c.authService.CompleteMFALogin(request.MFAToken, request.Code, ctx.ClientIP())
+	if err != nil {
+		log.Println("Login MFA failed : ", err)
+		respondMFAError(ctx, err)
+		return
+	}
+
+	response := toTokenResponse(pair)
+	response.Message = "Login success"
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *AuthController) BeginMFAEnrollment(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	enrollment, err := c.authService.BeginMFAEnrollment(user.(*models.User).ID)
+	if err != nil {
+		log.Println("Begin MFA enrollment failed : ", err)
+		respondMFAError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.MFAEnrollResponse{Secret: enrollment.Secret, OTPAuthURI: enrollment.OTPAuthURI})
+}
+
+func (c *AuthController) ConfirmMFAEnrollment(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var request dto.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := c.authService.ConfirmMFAEnrollment(user.(*models.User).ID, request.Code)
+	if err != nil {
+		log.Println("Confirm MFA enrollment failed : ", err)
+		respondMFAError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.MFARecoveryCodesResponse{
+		Message:       "Two-factor authentication enabled, store the recovery codes in a safe place",
+		RecoveryCodes: codes,
+	})
+}
+
+func (c *AuthController) DisableMFA(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var request dto.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.authService.DisableMFA(user.(*models.User).ID, request.Code, ctx.ClientIP()); err != nil {
+		log.Println("Disable MFA failed : ", err)
+		respondMFAError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func respondMFAError(ctx *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrInvalidMFAResponse):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.As(err, &throttled):
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotEnabled), errors.Is(err, services.ErrMFAEnrollNotBegun):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotConfigured):
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (c *AuthController) Refresh(ctx *gin.Context) {
+	var request dto.RefreshRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		log.Println("Refresh failed : ", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, err := c.authService.Refresh(request.RefreshToken)
+	if err != nil {
+		log.Println("Refresh failed : ", err)
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, toTokenResponse(pair))
+}
+
+func (c *AuthController) Logout(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	token := ctx.GetString("token")
+
+	// the body is optional
+	var request dto.LogoutRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			log.Println("Logout failed : ", err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := c.authService.Logout(user.(*models.User).ID, token, request.RefreshToken); err != nil {
+		log.Println("Logout failed : ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logout success"})
+}
+
+func (c *AuthController) LogoutAll(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "Login success", "token": token})
+	if err := c.authService.LogoutAll(user.(*models.User).ID, ctx.GetString("token")); err != nil {
+		log.Println("Logout all failed : ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logout success"})
+}
+
+// Sessions lists the active sessions of the user, with the device and IP they were last used from.
+func (c *AuthController) Sessions(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	list, err := c.authService.ListSessions(user.(*models.User).ID, ctx.GetString("token"))
+	if err != nil {
+		log.Println("List sessions failed : ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, list)
+}
+
+// JWKS publishes the public keys verifying access tokens, see RFC 7517.
+func (c *AuthController) JWKS(ctx *gin.Context) {
+	// verifiers may cache the keys, a new key is published before it starts signing
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, c.authService.JWKS())
+}
+
+func (c *AuthController) VerifyEmail(ctx *gin.Context) {
+	var request dto.VerifyEmailRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.authService.VerifyEmail(request.Token); err != nil {
+		log.Println("Verify email failed : ", err)
+		respondActionTokenError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Email verified, refresh your token to start selling"})
+}
+
+func (c *AuthController) ResendVerification(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := c.authService.SendVerificationEmail(user.(*models.User).ID); err != nil {
+		log.Println("Resend verification failed : ", err)
+		if errors.Is(err, services.ErrEmailAlreadyVerified) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+func (c *AuthController) RequestPasswordReset(ctx *gin.Context) {
+	var request dto.PasswordResetRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.authService.RequestPasswordReset(request.Email)
+
+	// the same answer whether the account exists or not
+	ctx.JSON(http.StatusAccepted, gin.H{"message": "If an account exists for this email, a reset link has been sent"})
+}
+
+func (c *AuthController) ConfirmPasswordReset(ctx *gin.Context) {
+	var request dto.PasswordResetConfirmRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.authService.ResetPassword(request.Token, request.NewPassword); err != nil {
+		log.Println("Confirm password reset failed : ", err)
+		respondActionTokenError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Password changed, please log in again"})
+}
+
+func respondActionTokenError(ctx *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidActionToken) || isPasswordPolicyError(err) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// isPasswordPolicyError reports whether a new password was rejected by the password policy.
+func isPasswordPolicyError(err error) bool {
+	return errors.Is(err, passwords.ErrPasswordTooShort) ||
+		errors.Is(err, passwords.ErrPasswordTooLong) ||
+		errors.Is(err, passwords.ErrPasswordBreached)
+}
+
+func toTokenResponse(pair *services.TokenPair) dto.TokenResponse {
+	return dto.TokenResponse{
+		Token:        pair.AccessToken,
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(pair.ExpiresIn.Seconds()),
+	}
+}
+
+// OIDCStart redirects the browser to the identity provider.
+func (c *AuthController) OIDCStart(ctx *gin.Context) {
+	authorization, err := c.authService.StartOIDCLogin(ctx.Param("provider"))
+	if err != nil {
+		log.Println("OIDC start failed : Provider = ", ctx.Param("provider"), ", Error = ", err)
+		respondOIDCError(ctx, err)
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcStateCookie, authorization.StateCookie, 600, "/auth/oidc", "", ctx.Request.TLS != nil, true)
+	ctx.Redirect(http.StatusFound, authorization.AuthURL)
+}
+
+// OIDCCallback is the redirect URL registered at the identity provider.
+func (c *AuthController) OIDCCallback(ctx *gin.Context) {
+	stateCookie, _ := ctx.Cookie(oidcStateCookie)
+	// the state is used once, whatever the outcome
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", ctx.Request.TLS != nil, true)
+
+	if providerError := ctx.Query("error"); providerError != "" {
+		log.Println("OIDC callback failed : Provider = ", ctx.Param("provider"), ", Error = ", providerError)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "login was not completed at the identity provider: " + providerError})
+		return
+	}
+	code := ctx.Query("code")
+	if code == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	result, err := c.authService.CompleteOIDCLogin(ctx.Param("provider"), code, ctx.Query("state"), stateCookie)
+	if err != nil {
+		log.Println("OIDC callback failed : Provider = ", ctx.Param("provider"), ", Error = ", err)
+		respondOIDCError(ctx, err)
+		return
+	}
+
+	respondLoginResult(ctx, result)
+}
+
+func respondOIDCError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, oidc.ErrUnknownProvider):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOIDCStateMismatch):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, oidc.ErrInvalidIDToken):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": oidc.ErrInvalidIDToken.Error()})
+	case errors.Is(err, services.ErrOIDCEmailNotVerified):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOIDCProvider):
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": services.ErrOIDCProvider.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
 }
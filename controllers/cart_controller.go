@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ICartController interface {
+	FindAll(c *gin.Context)
+	Add(c *gin.Context)
+	Update(c *gin.Context)
+	Remove(c *gin.Context)
+	Clear(c *gin.Context)
+	Checkout(c *gin.Context)
+}
+
+type CartController struct {
+	cartService services.ICartService
+}
+
+func NewCartController(cartService services.ICartService) ICartController {
+	return &CartController{cartService: cartService}
+}
+
+func (c *CartController) FindAll(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in CartController.FindAll")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	cart, err := c.cartService.FindAll(userId)
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, cart)
+}
+
+func (c *CartController) Add(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in CartController.Add")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	var input dto.AddCartItemInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cart, err := c.cartService.Add(userId, input)
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, cart)
+}
+
+func (c *CartController) Update(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in CartController.Update")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	itemId, err := strconv.ParseUint(ctx.Param("itemId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+	var input dto.UpdateCartItemInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cart, err := c.cartService.Update(userId, uint(itemId), input)
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, cart)
+}
+
+func (c *CartController) Remove(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in CartController.Remove")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	itemId, err := strconv.ParseUint(ctx.Param("itemId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	cart, err := c.cartService.Remove(userId, uint(itemId))
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, cart)
+}
+
+func (c *CartController) Clear(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in CartController.Clear")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	if err := c.cartService.Clear(userId); err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Cart cleared"})
+}
+
+func (c *CartController) Checkout(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in CartController.Checkout")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	orders, err := c.cartService.Checkout(userId)
+	if err != nil {
+		log.Println("Failed to checkout in CartController.Checkout", userId, err)
+		respondCartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, orders)
+}
+
+func respondCartError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCartEmpty):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrItemOutOfStock):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPaymentFailed):
+		ctx.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
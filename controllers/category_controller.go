@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ICategoryController interface {
+	FindAll(c *gin.Context)
+	FindById(c *gin.Context)
+	Create(c *gin.Context)
+	Update(c *gin.Context)
+	Delete(c *gin.Context)
+	FindAllTags(c *gin.Context)
+}
+
+type CategoryController struct {
+	categoryService services.ICategoryService
+}
+
+func NewCategoryController(categoryService services.ICategoryService) ICategoryController {
+	return &CategoryController{categoryService: categoryService}
+}
+
+func (c *CategoryController) FindAll(ctx *gin.Context) {
+	categories, err := c.categoryService.FindAll()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Println(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, categories)
+}
+
+func (c *CategoryController) FindById(ctx *gin.Context) {
+	categoryId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	category, err := c.categoryService.FindById(uint(categoryId))
+	if err != nil {
+		respondCategoryError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, category)
+}
+
+func (c *CategoryController) Create(ctx *gin.Context) {
+	var input dto.CreateCategoryInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	category, err := c.categoryService.Create(input)
+	if err != nil {
+		respondCategoryError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, category)
+}
+
+func (c *CategoryController) Update(ctx *gin.Context) {
+	categoryId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var input dto.UpdateCategoryInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	category, err := c.categoryService.Update(uint(categoryId), input)
+	if err != nil {
+		respondCategoryError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, category)
+}
+
+func (c *CategoryController) Delete(ctx *gin.Context) {
+	categoryId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if err := c.categoryService.Delete(uint(categoryId)); err != nil {
+		respondCategoryError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
+
+func (c *CategoryController) FindAllTags(ctx *gin.Context) {
+	tags, err := c.categoryService.FindAllTags()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Println(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, tags)
+}
+
+func respondCategoryError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+	case errors.Is(err, services.ErrInvalidCategoryParent):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCategoryHasChildren):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"gin-freemarket/dto"
 	"gin-freemarket/models"
 	"gin-freemarket/services"
@@ -13,6 +14,7 @@ import (
 
 type IItemController interface {
 	FindAll(c *gin.Context)
+	Search(c *gin.Context)
 	FindById(c *gin.Context)
 	Create(c *gin.Context)
 	Update(c *gin.Context)
@@ -28,8 +30,22 @@ func NewItemController(itemService services.IItemService) IItemController {
 }
 
 func (c *ItemController) FindAll(ctx *gin.Context) {
-	items, err := c.itemService.FindAll()
+	var query dto.ItemListQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "min_price must not be greater than max_price"})
+		return
+	}
+
+	items, err := c.itemService.FindAll(query)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		log.Println(err)
 		return
@@ -37,6 +53,26 @@ func (c *ItemController) FindAll(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, items)
 }
 
+func (c *ItemController) Search(ctx *gin.Context) {
+	var query dto.ItemSearchQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := c.itemService.Search(query)
+	if err != nil {
+		if errors.Is(err, services.ErrEmptySearchQuery) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Println(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, results)
+}
+
 func (c *ItemController) FindById(ctx *gin.Context) {
 	id := ctx.Param("id")
 	itemId, err := strconv.Atoi(id)
@@ -70,6 +106,10 @@ func (c *ItemController) Create(ctx *gin.Context) {
 	}
 	item, err := c.itemService.Create(input, userId)
 	if err != nil {
+		if errors.Is(err, services.ErrCategoryNotFound) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -85,8 +125,6 @@ func (c *ItemController) Update(ctx *gin.Context) {
 		return
 	}
 
-	userId := user.(*models.User).ID
-
 	id := ctx.Param("id")
 	itemId, err := strconv.Atoi(id)
 	if err != nil {
@@ -99,8 +137,16 @@ func (c *ItemController) Update(ctx *gin.Context) {
 		log.Println(err)
 		return
 	}
-	item, err := c.itemService.Update(uint(itemId), input, userId)
+	item, err := c.itemService.Update(uint(itemId), input, user.(*models.User))
 	if err != nil {
+		if errors.Is(err, services.ErrCategoryNotFound) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrNotItemOwner) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -116,16 +162,18 @@ func (c *ItemController) Delete(ctx *gin.Context) {
 		return
 	}
 
-	userId := user.(*models.User).ID
-
 	id := ctx.Param("id")
 	itemId, err := strconv.Atoi(id)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
-	err = c.itemService.Delete(uint(itemId), userId)
+	err = c.itemService.Delete(uint(itemId), user.(*models.User))
 	if err != nil {
+		if errors.Is(err, services.ErrNotItemOwner) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
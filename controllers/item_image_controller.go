@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/services"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// multipartOverhead is the room left for multipart headers and boundaries on top of the image itself
+const multipartOverhead = 64 << 10
+
+type IItemImageController interface {
+	Upload(c *gin.Context)
+	Delete(c *gin.Context)
+	Reorder(c *gin.Context)
+}
+
+type ItemImageController struct {
+	itemImageService services.IItemImageService
+}
+
+func NewItemImageController(itemImageService services.IItemImageService) IItemImageController {
+	return &ItemImageController{itemImageService: itemImageService}
+}
+
+func (c *ItemImageController) Upload(ctx *gin.Context) {
+	user, exists := ctx.Get("user")
+	if !exists {
+		log.Println("No user Authenticated ")
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	itemId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, services.MaxImageSize+multipartOverhead)
+	file, err := ctx.FormFile("image")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrImageTooLarge.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "image file is required"})
+		return
+	}
+	if file.Size > services.MaxImageSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrImageTooLarge.Error()})
+		return
+	}
+
+	opened, err := file.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer opened.Close()
+	data, err := io.ReadAll(io.LimitReader(opened, services.MaxImageSize+1))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	image, err := c.itemImageService.Upload(uint(itemId), user.(*models.User), data)
+	if err != nil {
+		respondItemImageError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, image)
+}
+
+func (c *ItemImageController) Delete(ctx *gin.Context) {
+	user, exists := ctx.Get("user")
+	if !exists {
+		log.Println("No user Authenticated ")
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	itemId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	imageId, err := strconv.Atoi(ctx.Param("imageId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := c.itemImageService.Delete(uint(itemId), uint(imageId), user.(*models.User)); err != nil {
+		respondItemImageError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+}
+
+func (c *ItemImageController) Reorder(ctx *gin.Context) {
+	user, exists := ctx.Get("user")
+	if !exists {
+		log.Println("No user Authenticated ")
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	itemId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var input dto.ReorderItemImagesInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	images, err := c.itemImageService.Reorder(uint(itemId), input.ImageIDs, user.(*models.User))
+	if err != nil {
+		respondItemImageError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, images)
+}
+
+func respondItemImageError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotItemOwner):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrImageTooLarge), errors.Is(err, services.ErrTooManyPixels):
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnsupportedImageType):
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTooManyImages):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidImageOrder):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type IOrderController interface {
+	FindAll(c *gin.Context)
+	FindById(c *gin.Context)
+	FindSales(c *gin.Context)
+	FindHistory(c *gin.Context)
+	Ship(c *gin.Context)
+	MarkDelivered(c *gin.Context)
+	ConfirmReceipt(c *gin.Context)
+	Cancel(c *gin.Context)
+}
+
+type OrderController struct {
+	orderService services.IOrderService
+}
+
+func NewOrderController(orderService services.IOrderService) IOrderController {
+	return &OrderController{orderService: orderService}
+}
+
+func (c *OrderController) FindAll(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.FindAll")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	orders, err := c.orderService.FindAll(userId)
+	if err != nil {
+		log.Println("Failed to find all orders in OrderController.FindAll", userId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, orders)
+}
+
+func (c *OrderController) FindById(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.FindById")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	order, err := c.orderService.FindById(userId, uint(id))
+	if err != nil {
+		respondOrderError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, order)
+}
+
+func (c *OrderController) FindSales(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.FindSales")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	orders, err := c.orderService.FindSales(userId)
+	if err != nil {
+		log.Println("Failed to find sales in OrderController.FindSales", userId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, orders)
+}
+
+func (c *OrderController) FindHistory(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.FindHistory")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	history, err := c.orderService.FindHistory(userId, uint(id))
+	if err != nil {
+		respondOrderError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, history)
+}
+
+func (c *OrderController) Ship(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.Ship")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+	var input dto.ShipOrderInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, err := c.orderService.Ship(userId, uint(id), input)
+	if err != nil {
+		respondOrderError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, order)
+}
+
+func (c *OrderController) MarkDelivered(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.MarkDelivered")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	order, err := c.orderService.MarkDelivered(userId, uint(id))
+	if err != nil {
+		respondOrderError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, order)
+}
+
+func (c *OrderController) ConfirmReceipt(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.ConfirmReceipt")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	order, err := c.orderService.ConfirmReceipt(userId, uint(id))
+	if err != nil {
+		respondOrderError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, order)
+}
+
+func (c *OrderController) Cancel(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in OrderController.Cancel")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+	var input dto.CancelOrderInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, err := c.orderService.Cancel(userId, uint(id), input)
+	if err != nil {
+		respondOrderError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, order)
+}
+
+func respondOrderError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+	case errors.Is(err, services.ErrNotOrderSeller):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidOrderTransition), errors.Is(err, services.ErrCancelWindowExpired):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPaymentFailed):
+		// capture, void and refund only fail when the payment provider rejects them
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/services"
+	"gin-freemarket/utils/payments"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookPayloadSize limits the body read from an unauthenticated caller
+const maxWebhookPayloadSize = 1 << 20
+
+type IPaymentWebhookController interface {
+	Receive(c *gin.Context)
+}
+
+type PaymentWebhookController struct {
+	paymentWebhookService services.IPaymentWebhookService
+}
+
+func NewPaymentWebhookController(paymentWebhookService services.IPaymentWebhookService) IPaymentWebhookController {
+	return &PaymentWebhookController{paymentWebhookService: paymentWebhookService}
+}
+
+// Receive is called by the payment provider. The signature is computed over the raw body,
+// so the body is read as is and not bound to a struct.
+func (c *PaymentWebhookController) Receive(ctx *gin.Context) {
+	payload, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxWebhookPayloadSize))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+		return
+	}
+
+	event, duplicate, err := c.paymentWebhookService.Receive(payload, ctx.GetHeader(payments.WebhookSignatureHeader))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidWebhookSignature):
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidWebhookPayload):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			// the provider redelivers on 5xx
+			log.Println("Failed to process payment webhook in PaymentWebhookController.Receive", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process webhook"})
+		}
+		return
+	}
+
+	response := dto.WebhookEventResponse{EventID: event.EventID, Status: event.Status, Duplicate: duplicate}
+	// pending events are stored and retried by the app, the provider does not need to send them again
+	if event.Status == models.WebhookEventPending {
+		ctx.JSON(http.StatusAccepted, response)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"gin-freemarket/dto"
 	"gin-freemarket/models"
 	"gin-freemarket/services"
@@ -8,12 +9,14 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type IPurchaseController interface {
 	Create(c *gin.Context)
 	FindAll(c *gin.Context)
 	FindById(c *gin.Context)
+	Cancel(c *gin.Context)
 }
 
 type PurchaseController struct {
@@ -26,7 +29,13 @@ func NewPurchaseController(purchaseService services.IPurchaseService) IPurchaseC
 
 func (c *PurchaseController) Create(ctx *gin.Context) {
 	// Get user from context
-	userID := c.GetUint("userID")
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in PurchaseController.Create")
+		ctx.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userID := user.(*models.User).ID
 
 	var input dto.PurchaseItemInput
 	if err := ctx.ShouldBindJSON(&input); err != nil {
@@ -38,6 +47,10 @@ func (c *PurchaseController) Create(ctx *gin.Context) {
 	purchase, err := c.purchaseService.Create(userID, input)
 	if err != nil {
 		log.Println("Failed to create purchase in PurchaseController.Create", input.ItemID, userID, err)
+		if errors.Is(err, services.ErrPaymentFailed) {
+			ctx.JSON(402, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -87,3 +100,43 @@ func (c *PurchaseController) FindById(ctx *gin.Context) {
 
 	ctx.JSON(200, purchase)
 }
+
+func (c *PurchaseController) Cancel(ctx *gin.Context) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed to get user from context in PurchaseController.Cancel")
+		ctx.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userId := user.(*models.User).ID
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	var input dto.CancelOrderInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	purchase, err := c.purchaseService.Cancel(userId, uint(id), input)
+	if err != nil {
+		log.Println("Failed to cancel purchase in PurchaseController.Cancel", id, userId, err)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			ctx.JSON(404, gin.H{"error": "purchase not found"})
+		case errors.Is(err, services.ErrCancelWindowExpired), errors.Is(err, services.ErrInvalidOrderTransition):
+			ctx.JSON(409, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrPaymentFailed):
+			ctx.JSON(502, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(500, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctx.JSON(200, purchase)
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"gin-freemarket/services"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type IQueueController interface {
+	Status(c *gin.Context)
+}
+
+type QueueController struct {
+	queueService services.IQueueService
+}
+
+func NewQueueController(queueService services.IQueueService) IQueueController {
+	return &QueueController{queueService: queueService}
+}
+
+// Status needs no login, the ticket ID is a random secret handed out with the queued response.
+func (c *QueueController) Status(ctx *gin.Context) {
+	ticketID := ctx.Query("ticket")
+	if ticketID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ticket is required"})
+		return
+	}
+
+	status, err := c.queueService.Status(ticketID)
+	if err != nil {
+		log.Println("Queue status failed : ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Issue on checking the queue"})
+		return
+	}
+	ctx.JSON(http.StatusOK, status)
+}
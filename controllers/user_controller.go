@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type IUserController interface {
+	UpdateRole(c *gin.Context)
+	Suspend(c *gin.Context)
+	Unsuspend(c *gin.Context)
+	UnlockLogin(c *gin.Context)
+}
+
+type UserController struct {
+	userService services.IUserService
+}
+
+func NewUserController(userService services.IUserService) IUserController {
+	return &UserController{userService: userService}
+}
+
+func (c *UserController) UpdateRole(ctx *gin.Context) {
+	admin, userID, ok := userAdminParams(ctx)
+	if !ok {
+		return
+	}
+
+	var input dto.UpdateUserRoleInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.userService.UpdateRole(admin.ID, userID, input)
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+func (c *UserController) Suspend(ctx *gin.Context) {
+	admin, userID, ok := userAdminParams(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.userService.Suspend(admin.ID, userID)
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+func (c *UserController) Unsuspend(ctx *gin.Context) {
+	admin, userID, ok := userAdminParams(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.userService.Unsuspend(admin.ID, userID)
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// UnlockLogin lets the user log in again after being locked out by failed attempts.
+func (c *UserController) UnlockLogin(ctx *gin.Context) {
+	admin, userID, ok := userAdminParams(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.userService.UnlockLogin(admin.ID, userID)
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// userAdminParams returns the admin from context and the target user ID from the path.
+func userAdminParams(ctx *gin.Context) (*models.User, uint, bool) {
+	user, ok := ctx.Get("user")
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, 0, false
+	}
+	userID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, 0, false
+	}
+	return user.(*models.User), uint(userID), true
+}
+
+func respondUserError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, services.ErrCannotChangeOwnAccount):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,43 @@
+package dto
+
+import (
+	"gin-freemarket/models"
+	"time"
+)
+
+type CreateAPIKeyInput struct {
+	Name   string               `json:"name" binding:"required,max=100"`
+	Scopes []models.APIKeyScope `json:"scopes" binding:"required,min=1,dive,oneof=items:read items:write purchases:read purchases:write cart:read cart:write orders:read orders:write"`
+	// ExpiresAt is optional, a key without it is valid until revoked
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type APIKeyResponse struct {
+	ID         uint                 `json:"id"`
+	Name       string               `json:"name"`
+	Prefix     string               `json:"prefix"`
+	Scopes     []models.APIKeyScope `json:"scopes"`
+	ExpiresAt  *time.Time           `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time           `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time           `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time            `json:"created_at"`
+}
+
+// CreatedAPIKeyResponse carries the key itself, which is shown only once.
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func ToAPIKeyResponse(key *models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
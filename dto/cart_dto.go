@@ -0,0 +1,40 @@
+package dto
+
+import "gin-freemarket/models"
+
+type AddCartItemInput struct {
+	ItemID   uint `json:"item_id" binding:"required,min=1"`
+	Quantity uint `json:"quantity" binding:"required,min=1"`
+}
+
+type UpdateCartItemInput struct {
+	Quantity uint `json:"quantity" binding:"required,min=1"`
+}
+
+type CartLineResponse struct {
+	ItemID     uint         `json:"item_id"`
+	Quantity   uint         `json:"quantity"`
+	TotalPrice int          `json:"total_price"`
+	Item       ItemResponse `json:"item"`
+}
+
+type CartResponse struct {
+	Lines      []CartLineResponse `json:"lines"`
+	TotalPrice int                `json:"total_price"`
+}
+
+// ToCartResponse returns the cart lines with the current item prices
+func ToCartResponse(cartItems []models.CartItem) *CartResponse {
+	response := &CartResponse{Lines: make([]CartLineResponse, len(cartItems))}
+	for i, cartItem := range cartItems {
+		lineTotal := int(cartItem.Item.Price * cartItem.Quantity)
+		response.Lines[i] = CartLineResponse{
+			ItemID:     cartItem.ItemID,
+			Quantity:   cartItem.Quantity,
+			TotalPrice: lineTotal,
+			Item:       toItemResponse(&cartItem.Item),
+		}
+		response.TotalPrice += lineTotal
+	}
+	return response
+}
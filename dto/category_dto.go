@@ -0,0 +1,59 @@
+package dto
+
+import "gin-freemarket/models"
+
+type CreateCategoryInput struct {
+	Name     string `json:"name" binding:"required,min=1,max=100"`
+	Slug     string `json:"slug" binding:"required,min=1,max=100,lowercase"`
+	ParentID *uint  `json:"parent_id" binding:"omitnil,min=1"`
+}
+
+// UpdateCategoryInput updates only the given fields. parent_id = 0 moves the category to the top level.
+type UpdateCategoryInput struct {
+	Name     *string `json:"name" binding:"omitnil,min=1,max=100"`
+	Slug     *string `json:"slug" binding:"omitnil,min=1,max=100,lowercase"`
+	ParentID *uint   `json:"parent_id" binding:"omitnil"`
+}
+
+type CategoryResponse struct {
+	ID       uint               `json:"id"`
+	Name     string             `json:"name"`
+	Slug     string             `json:"slug"`
+	ParentID *uint              `json:"parent_id"`
+	Children []CategoryResponse `json:"children"`
+}
+
+// ToCategoryTree nests a flat category list under its top-level categories.
+// Categories whose parent is not in the list are treated as top-level.
+func ToCategoryTree(categories []models.Category) []CategoryResponse {
+	known := make(map[uint]bool, len(categories))
+	children := make(map[uint][]models.Category)
+	for _, category := range categories {
+		known[category.ID] = true
+	}
+
+	var roots []models.Category
+	for _, category := range categories {
+		if category.ParentID != nil && known[*category.ParentID] {
+			children[*category.ParentID] = append(children[*category.ParentID], category)
+			continue
+		}
+		roots = append(roots, category)
+	}
+
+	var build func(list []models.Category) []CategoryResponse
+	build = func(list []models.Category) []CategoryResponse {
+		responses := make([]CategoryResponse, len(list))
+		for i, category := range list {
+			responses[i] = CategoryResponse{
+				ID:       category.ID,
+				Name:     category.Name,
+				Slug:     category.Slug,
+				ParentID: category.ParentID,
+				Children: build(children[category.ID]),
+			}
+		}
+		return responses
+	}
+	return build(roots)
+}
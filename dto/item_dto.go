@@ -1,16 +1,78 @@
 package dto
 
+import "gin-freemarket/models"
+
 type CreateItemInput struct {
-	Name        string `json:"name" binding:"required,min=3,max=200"`
-	Price       uint   `json:"price" binding:"required,min=1,max=100000000"`
-	Description string `json:"description" binding:"required,min=3,max=10000"`
-	Quantity    uint   `json:"quantity" binding:"required,min=1"`
+	Name        string   `json:"name" binding:"required,min=3,max=200"`
+	Price       uint     `json:"price" binding:"required,min=1,max=100000000"`
+	Description string   `json:"description" binding:"required,min=3,max=10000"`
+	Quantity    uint     `json:"quantity" binding:"required,min=1"`
+	CategoryID  *uint    `json:"category_id" binding:"omitnil,min=1"`
+	Tags        []string `json:"tags" binding:"omitempty,max=10,dive,min=1,max=50"`
 }
 
+// UpdateItemInput updates only the given fields.
+// category_id = 0 removes the category, and tags replaces the whole tag set when present (an empty list clears it).
 type UpdateItemInput struct {
-	Name        *string `json:"name" binding:"omitnil,min=3,max=200"`
-	Price       *uint   `json:"price" binding:"omitnil,min=1,max=100000000"`
-	Description *string `json:"description" binding:"omitnil,min=3,max=10000"`
-	SoldOut     *bool   `json:"sold_out" binding:"omitnil"`
-	Quantity    *uint   `json:"quantity" binding:"omitnil,min=1"`
+	Name        *string  `json:"name" binding:"omitnil,min=3,max=200"`
+	Price       *uint    `json:"price" binding:"omitnil,min=1,max=100000000"`
+	Description *string  `json:"description" binding:"omitnil,min=3,max=10000"`
+	SoldOut     *bool    `json:"sold_out" binding:"omitnil"`
+	Quantity    *uint    `json:"quantity" binding:"omitnil,min=1"`
+	CategoryID  *uint    `json:"category_id" binding:"omitnil"`
+	Tags        []string `json:"tags" binding:"omitnil,max=10,dive,min=1,max=50"`
+}
+
+// ItemListQuery is bound from the query string of GET /items.
+// Either Cursor (keyset pagination) or Page (offset pagination) is used, Cursor wins when both are set.
+type ItemListQuery struct {
+	Cursor      string `form:"cursor"`
+	Page        int    `form:"page" binding:"omitempty,min=1"`
+	Limit       int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	MinPrice    *uint  `form:"min_price" binding:"omitnil"`
+	MaxPrice    *uint  `form:"max_price" binding:"omitnil"`
+	UserID      *uint  `form:"user_id" binding:"omitnil,min=1"`
+	SoldOut     *bool  `form:"sold_out" binding:"omitnil"`
+	MinQuantity *uint  `form:"min_quantity" binding:"omitnil"`
+	// CategoryID matches the category and all of its descendants
+	CategoryID *uint `form:"category_id" binding:"omitnil,min=1"`
+	// Tag may be repeated, items must carry every given tag
+	Tags  []string `form:"tag" binding:"omitempty,max=5,dive,min=1,max=50"`
+	Sort  string   `form:"sort" binding:"omitempty,oneof=price created_at name"`
+	Order string   `form:"order" binding:"omitempty,oneof=asc desc"`
+}
+
+type ItemListResponse struct {
+	Items      []models.Item `json:"items"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	TotalCount int64         `json:"total_count"`
+	Page       int           `json:"page,omitempty"`
+	Limit      int           `json:"limit"`
+}
+
+// ItemSearchQuery is bound from the query string of GET /items/search.
+// q accepts plain words, "quoted phrases" and prefix terms ending with *.
+type ItemSearchQuery struct {
+	Q     string `form:"q" binding:"required,min=1,max=200"`
+	Page  int    `form:"page" binding:"omitempty,min=1"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// ItemSearchHighlight holds HTML snippets: the item text is escaped and matches are enclosed in <mark>.
+type ItemSearchHighlight struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ItemSearchResult struct {
+	Item       models.Item         `json:"item"`
+	Rank       float64             `json:"rank"`
+	Highlights ItemSearchHighlight `json:"highlights"`
+}
+
+type ItemSearchResponse struct {
+	Results    []ItemSearchResult `json:"results"`
+	TotalCount int64              `json:"total_count"`
+	Page       int                `json:"page"`
+	Limit      int                `json:"limit"`
 }
@@ -0,0 +1,5 @@
+package dto
+
+type ReorderItemImagesInput struct {
+	ImageIDs []uint `json:"image_ids" binding:"required,min=1,dive,min=1"`
+}
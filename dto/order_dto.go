@@ -0,0 +1,114 @@
+package dto
+
+import (
+	"gin-freemarket/models"
+	"time"
+)
+
+type ShipOrderInput struct {
+	TrackingNumber string `json:"tracking_number" binding:"required,min=1,max=100"`
+}
+
+type CancelOrderInput struct {
+	Reason string `json:"reason" binding:"required,min=1,max=500"`
+}
+
+type OrderLineResponse struct {
+	ID         uint         `json:"id"`
+	ItemID     uint         `json:"item_id"`
+	Price      int          `json:"price"`
+	Quantity   int          `json:"quantity"`
+	TotalPrice int          `json:"total_price"`
+	Item       ItemResponse `json:"item"`
+}
+
+type OrderResponse struct {
+	ID             uint                `json:"id"`
+	OrderNumber    string              `json:"order_number"`
+	UserID         uint                `json:"user_id"`
+	Status         models.OrderStatus  `json:"status"`
+	Subtotal       int                 `json:"subtotal"`
+	Tax            int                 `json:"tax"`
+	ShippingFee    int                 `json:"shipping_fee"`
+	Discount       int                 `json:"discount"`
+	TotalPrice     int                 `json:"total_price"`
+	PaymentStatus  string              `json:"payment_status,omitempty"`
+	TrackingNumber string              `json:"tracking_number,omitempty"`
+	ShippedAt      *time.Time          `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time          `json:"delivered_at,omitempty"`
+	CompletedAt    *time.Time          `json:"completed_at,omitempty"`
+	CancelledAt    *time.Time          `json:"cancelled_at,omitempty"`
+	CancelReason   string              `json:"cancel_reason,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+	Lines          []OrderLineResponse `json:"lines"`
+}
+
+// ToOrderResponse returns order information including its line items
+func ToOrderResponse(order *models.Order) *OrderResponse {
+	response := &OrderResponse{
+		ID:             order.ID,
+		OrderNumber:    order.OrderNumber,
+		UserID:         order.UserID,
+		Status:         order.Status,
+		Subtotal:       order.Subtotal,
+		Tax:            order.Tax,
+		ShippingFee:    order.ShippingFee,
+		Discount:       order.Discount,
+		TotalPrice:     order.TotalPrice,
+		PaymentStatus:  order.PaymentStatus,
+		TrackingNumber: order.TrackingNumber,
+		ShippedAt:      order.ShippedAt,
+		DeliveredAt:    order.DeliveredAt,
+		CompletedAt:    order.CompletedAt,
+		CancelledAt:    order.CancelledAt,
+		CancelReason:   order.CancelReason,
+		CreatedAt:      order.CreatedAt,
+		UpdatedAt:      order.UpdatedAt,
+		Lines:          make([]OrderLineResponse, len(order.Lines)),
+	}
+	for i, line := range order.Lines {
+		response.Lines[i] = OrderLineResponse{
+			ID:         line.ID,
+			ItemID:     line.ItemID,
+			Price:      line.Price,
+			Quantity:   line.Quantity,
+			TotalPrice: line.TotalPrice,
+			Item:       toItemResponse(&line.Item),
+		}
+	}
+	return response
+}
+
+func toItemResponse(item *models.Item) ItemResponse {
+	return ItemResponse{
+		ID:          item.ID,
+		Name:        item.Name,
+		Price:       item.Price,
+		Description: item.Description,
+		SoldOut:     item.SoldOut,
+		Quantity:    int(item.Quantity),
+	}
+}
+
+type OrderStatusHistoryResponse struct {
+	FromStatus models.OrderStatus `json:"from_status,omitempty"`
+	ToStatus   models.OrderStatus `json:"to_status"`
+	ChangedBy  *uint              `json:"changed_by"`
+	Note       string             `json:"note,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+func ToOrderStatusHistoryResponses(history []models.OrderStatusHistory) []OrderStatusHistoryResponse {
+	responses := make([]OrderStatusHistoryResponse, len(history))
+	for i, entry := range history {
+		responses[i] = OrderStatusHistoryResponse{
+			FromStatus: entry.FromStatus,
+			ToStatus:   entry.ToStatus,
+			ChangedBy:  entry.ChangedBy,
+			Note:       entry.Note,
+			CreatedAt:  entry.CreatedAt,
+		}
+	}
+	return responses
+}
@@ -24,39 +24,42 @@ type ItemResponse struct {
 	Quantity    int    `json:"quantity"`
 }
 
+// PurchaseResponse is the single item view of an order line kept for the /purchases API.
+// ID is the order line ID.
 type PurchaseResponse struct {
-	ID         uint         `json:"id"`
-	UserID     uint         `json:"user_id"`
-	ItemID     uint         `json:"item_id"`
-	Price      int          `json:"price"`
-	Quantity   int          `json:"quantity"`
-	TotalPrice int          `json:"total_price"`
-	CreatedAt  time.Time    `json:"created_at"`
-	UpdatedAt  time.Time    `json:"updated_at"`
-	User       UserResponse `json:"user"`
-	Item       ItemResponse `json:"item"`
+	ID          uint               `json:"id"`
+	OrderID     uint               `json:"order_id"`
+	OrderNumber string             `json:"order_number"`
+	Status      models.OrderStatus `json:"status"`
+	UserID      uint               `json:"user_id"`
+	ItemID      uint               `json:"item_id"`
+	Price       int                `json:"price"`
+	Quantity    int                `json:"quantity"`
+	TotalPrice  int                `json:"total_price"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	User        UserResponse       `json:"user"`
+	Item        ItemResponse       `json:"item"`
 }
 
-// ToPurchaseResponse returns purchase information including purchaser and item information
-func ToPurchaseResponse(purchase *models.Purchase) *PurchaseResponse {
+// ToPurchaseResponse returns purchase information including purchaser and item information.
+// The order line must be loaded with its order.
+func ToPurchaseResponse(line *models.OrderLine) *PurchaseResponse {
 	return &PurchaseResponse{
-		ID:         purchase.ID,
-		UserID:     purchase.UserID,
-		ItemID:     purchase.ItemID,
-		Price:      purchase.Price,
-		Quantity:   purchase.Quantity,
-		TotalPrice: purchase.TotalPrice,
-		CreatedAt:  purchase.CreatedAt,
-		UpdatedAt:  purchase.UpdatedAt,
+		ID:          line.ID,
+		OrderID:     line.OrderID,
+		OrderNumber: line.Order.OrderNumber,
+		Status:      line.Order.Status,
+		UserID:      line.Order.UserID,
+		ItemID:      line.ItemID,
+		Price:       line.Price,
+		Quantity:    line.Quantity,
+		TotalPrice:  line.TotalPrice,
+		CreatedAt:   line.CreatedAt,
+		UpdatedAt:   line.Order.UpdatedAt,
 		User: UserResponse{
-			ID: purchase.User.ID,
-		},
-		Item: ItemResponse{
-			ID:          purchase.Item.ID,
-			Name:        purchase.Item.Name,
-			Price:       purchase.Item.Price,
-			Description: purchase.Item.Description,
-			Quantity:    int(purchase.Item.Quantity),
+			ID: line.Order.UserID,
 		},
+		Item: toItemResponse(&line.Item),
 	}
 }
@@ -0,0 +1,35 @@
+package dto
+
+import "gin-freemarket/utils/sessions"
+
+// QueueStatusResponse is returned to requests waiting for a session and by GET /queue/status.
+type QueueStatusResponse struct {
+	Message string                `json:"message"`
+	Ticket  string                `json:"ticket"`
+	Status  sessions.TicketStatus `json:"status"`
+	// Position is 1 for the next ticket to be admitted
+	Position int64 `json:"position,omitempty"`
+	// EstimatedWaitSeconds is derived from the expiry of the current sessions, it grows while they stay active
+	EstimatedWaitSeconds int `json:"estimated_wait_seconds,omitempty"`
+	// PollAfterSeconds is the interval to poll GET /queue/status at
+	PollAfterSeconds int `json:"poll_after_seconds,omitempty"`
+}
+
+func ToQueueStatusResponse(ticket *sessions.Ticket) QueueStatusResponse {
+	response := QueueStatusResponse{
+		Ticket:               ticket.ID,
+		Status:               ticket.Status,
+		Position:             ticket.Position,
+		EstimatedWaitSeconds: int(ticket.EstimatedWait.Seconds()),
+	}
+	switch ticket.Status {
+	case sessions.TicketWaiting:
+		response.Message = "The site is busy, you are in the waiting room"
+		response.PollAfterSeconds = int(sessions.QueuePollInterval.Seconds())
+	case sessions.TicketAdmitted:
+		response.Message = "You have been admitted, retry your request"
+	default:
+		response.Message = "The ticket has expired, retry your request to queue again"
+	}
+	return response
+}
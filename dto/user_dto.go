@@ -0,0 +1,28 @@
+package dto
+
+import (
+	"gin-freemarket/models"
+	"time"
+)
+
+type UpdateUserRoleInput struct {
+	Role models.Role `json:"role" binding:"required,oneof=admin seller buyer"`
+}
+
+type UserAccountResponse struct {
+	ID          uint        `json:"id"`
+	Email       string      `json:"email"`
+	Role        models.Role `json:"role"`
+	SuspendedAt *time.Time  `json:"suspended_at,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+func ToUserAccountResponse(user *models.User) *UserAccountResponse {
+	return &UserAccountResponse{
+		ID:          user.ID,
+		Email:       user.Email,
+		Role:        user.Role,
+		SuspendedAt: user.SuspendedAt,
+		CreatedAt:   user.CreatedAt,
+	}
+}
@@ -0,0 +1,9 @@
+package dto
+
+import "gin-freemarket/models"
+
+type WebhookEventResponse struct {
+	EventID   string                    `json:"event_id"`
+	Status    models.WebhookEventStatus `json:"status"`
+	Duplicate bool                      `json:"duplicate"`
+}
@@ -7,11 +7,21 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
-	//	"gin-freemarket/models"
 	"gin-freemarket/middlewares"
+	"gin-freemarket/models"
 	"gin-freemarket/repositories"
 	"gin-freemarket/services"
+	"gin-freemarket/utils/idempotency"
+	"gin-freemarket/utils/jwtkeys"
+	"gin-freemarket/utils/loginguard"
+	"gin-freemarket/utils/mailer"
+	"gin-freemarket/utils/oidc"
+	"gin-freemarket/utils/payments"
+	"gin-freemarket/utils/sessions"
+	"gin-freemarket/utils/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -56,46 +66,152 @@ func initMetrics() {
 
 // Structure for setting up dependencies
 type Dependencies struct {
-	IItemController     controllers.IItemController
-	IAuthController     controllers.IAuthController
-	IPurchaseController controllers.IPurchaseController
-	AuthMiddleware      gin.HandlerFunc
-	SessionMiddleware   gin.HandlerFunc
-	WebMonitoring       middlewares.WebMonitoring
+	IItemController           controllers.IItemController
+	IItemImageController      controllers.IItemImageController
+	IAuthController           controllers.IAuthController
+	IPurchaseController       controllers.IPurchaseController
+	ICategoryController       controllers.ICategoryController
+	ICartController           controllers.ICartController
+	IUserController           controllers.IUserController
+	IAPIKeyController         controllers.IAPIKeyController
+	IQueueController          controllers.IQueueController
+	IOrderController          controllers.IOrderController
+	IPaymentWebhookController controllers.IPaymentWebhookController
+	AuthMiddleware            gin.HandlerFunc
+	IdempotencyMiddleware     gin.HandlerFunc
+	SessionMiddleware         gin.HandlerFunc
+	WebMonitoring             middlewares.WebMonitoring
+	BlobStore                 storage.BlobStore
 }
 
 // Function to initialize dependencies
 func setupDependencies(db *gorm.DB) *Dependencies {
+	// Category
+	categoryRepository := repositories.NewCategoryRepository(db)
+	tagRepository := repositories.NewTagRepository(db)
+	categoryService := services.NewCategoryService(categoryRepository, tagRepository, db)
+	categoryController := controllers.NewCategoryController(categoryService)
+
+	// Blob storage for item images
+	blobStore, err := storage.NewBlobStoreFromEnv()
+	if err != nil {
+		panic("failed to setup blob store: " + err.Error())
+	}
+
 	// Item
 	itemRepository := repositories.NewItemRepository(db)
-	itemService := services.NewItemService(itemRepository, db)
+	itemService := services.NewItemService(itemRepository, categoryRepository, tagRepository, blobStore, db)
 	itemController := controllers.NewItemController(itemService)
 
+	// Item image
+	itemImageRepository := repositories.NewItemImageRepository(db)
+	itemImageService := services.NewItemImageService(itemRepository, itemImageRepository, blobStore, db)
+	itemImageController := controllers.NewItemImageController(itemImageService)
+
 	// Auth
 	authRepository := repositories.NewAuthRepository(db)
-	authService := services.NewAuthService(authRepository, db)
+	// sessions in Redis, postgres or memory, see SESSION_STORE
+	sessionManager, err := sessions.NewSessionManagerFromEnv(db)
+	if err != nil {
+		panic("failed to setup session manager: " + err.Error())
+	}
+	// expired sessions free their slots for the waiting room
+	sessions.StartAdmissionLoop(sessionManager, sessions.QueuePollInterval)
+	// the postgres store keeps ended sessions until they are purged
+	sessions.StartPurgeLoop(sessionManager, 10*time.Minute)
+	keySet, err := jwtkeys.LoadKeySetFromEnv()
+	if err != nil {
+		panic("failed to load JWT keys: " + err.Error())
+	}
+	mailSender, err := mailer.NewMailerFromEnv()
+	if err != nil {
+		panic("failed to setup mailer: " + err.Error())
+	}
+	// identity providers for single sign-on, none unless OIDC_PROVIDERS is set
+	oidcProviders, err := oidc.LoadProvidersFromEnv()
+	if err != nil {
+		panic("failed to setup OIDC providers: " + err.Error())
+	}
+	// failed login counters in Redis
+	loginGuard := loginguard.NewGuardFromEnv()
+	authService := services.NewAuthService(authRepository, sessionManager, keySet, mailSender, oidcProviders, loginGuard, db)
 	authController := controllers.NewAuthController(authService)
 
-	// auth middlware
-	authMiddleware := middlewares.AuthMiddleware(authService)
+	// Waiting room for requests arriving while all sessions are taken
+	queueService := services.NewQueueService(sessionManager)
+	queueController := controllers.NewQueueController(queueService)
+
+	// User administration
+	userService := services.NewUserService(authRepository, sessionManager, loginGuard, db)
+	userController := controllers.NewUserController(userService)
+
+	// Personal API keys for scripts
+	apiKeyRepository := repositories.NewAPIKeyRepository(db)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepository, db)
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService)
+
+	// auth middlware, accepting access tokens and API keys
+	authMiddleware := middlewares.AuthMiddleware(authService, apiKeyService)
 	//session middleware
-	sessionMiddleware := middlewares.SessionMiddleware(authService)
+	sessionMiddleware := middlewares.SessionMiddleware(sessionManager)
+	// idempotency middleware, keys in Redis or in postgres when Redis is not available
+	idempotencyStore, err := idempotency.NewStoreFromEnv(db)
+	if err != nil {
+		panic("failed to setup idempotency store: " + err.Error())
+	}
+	idempotencyMiddleware := middlewares.IdempotencyMiddleware(idempotencyStore)
+
+	// Payment provider holding the buyer's money until receipt is confirmed
+	paymentGateway, err := payments.NewPaymentGatewayFromEnv()
+	if err != nil {
+		panic("failed to setup payment gateway: " + err.Error())
+	}
+
+	// captures, voids and refunds decided by order changes, retried until the provider accepts them
+	paymentOutbox := services.NewPaymentOutbox(paymentGateway, db)
+	paymentOutbox.StartRetryLoop(1 * time.Minute)
 
 	// Purchase
 	purchaseRepository := repositories.NewPurchaseRepository(db)
-	purchaseService := services.NewPurchaseService(purchaseRepository, itemRepository, db)
+	purchaseService := services.NewPurchaseService(purchaseRepository, itemRepository, paymentOutbox, db)
 	purchaseController := controllers.NewPurchaseController(purchaseService)
 
+	// Order
+	orderRepository := repositories.NewOrderRepository(db)
+	orderService := services.NewOrderService(orderRepository, paymentOutbox, db)
+	orderController := controllers.NewOrderController(orderService)
+
+	// Payment webhooks
+	webhookEventRepository := repositories.NewWebhookEventRepository(db)
+	paymentWebhookService := services.NewPaymentWebhookService(webhookEventRepository, db)
+	paymentWebhookService.StartRetryLoop(1 * time.Minute)
+	webhookController := controllers.NewPaymentWebhookController(paymentWebhookService)
+
+	// Cart
+	cartRepository := repositories.NewCartRepository(db)
+	cartService := services.NewCartService(cartRepository, itemRepository, orderRepository, paymentOutbox, db)
+	cartController := controllers.NewCartController(cartService)
+
 	// monitoring
 	webMonitoring := middlewares.NewPrometheusMonitorWebRequest()
 
 	return &Dependencies{
-		IItemController:     itemController,
-		IAuthController:     authController,
-		IPurchaseController: purchaseController,
-		AuthMiddleware:      authMiddleware,
-		SessionMiddleware:   sessionMiddleware,
-		WebMonitoring:       webMonitoring,
+		IItemController:           itemController,
+		IItemImageController:      itemImageController,
+		IAuthController:           authController,
+		IPurchaseController:       purchaseController,
+		ICategoryController:       categoryController,
+		ICartController:           cartController,
+		IUserController:           userController,
+		IAPIKeyController:         apiKeyController,
+		IQueueController:          queueController,
+		IOrderController:          orderController,
+		IPaymentWebhookController: webhookController,
+		AuthMiddleware:            authMiddleware,
+		IdempotencyMiddleware:     idempotencyMiddleware,
+		SessionMiddleware:         sessionMiddleware,
+		WebMonitoring:             webMonitoring,
+		BlobStore:                 blobStore,
 	}
 }
 
@@ -125,28 +241,129 @@ func main() {
 	itemRouter := router.Group("/items")
 	{
 		itemRouter.GET("", deps.IItemController.FindAll)
+		itemRouter.GET("/search", deps.IItemController.Search)
 		itemRouter.GET("/:id", deps.IItemController.FindById)
 
-		itemRouter.Use(deps.AuthMiddleware)
-		itemRouter.POST("", deps.IItemController.Create)
+		itemRouter.Use(middlewares.AllowAPIKey(models.ScopeItemsRead, models.ScopeItemsWrite), deps.AuthMiddleware)
+		// suspended users lose the permission to list items
+		itemRouter.POST("", middlewares.RequirePermission(models.PermissionItemsCreate), deps.IItemController.Create)
 		itemRouter.PUT("/:id", deps.IItemController.Update)
 		itemRouter.DELETE("/:id", deps.IItemController.Delete)
+
+		itemRouter.POST("/:id/images", deps.IItemImageController.Upload)
+		itemRouter.PUT("/:id/images/order", deps.IItemImageController.Reorder)
+		itemRouter.DELETE("/:id/images/:imageId", deps.IItemImageController.Delete)
+	}
+
+	// images stored on the local filesystem are served by the app itself
+	if localStore, ok := deps.BlobStore.(*storage.LocalBlobStore); ok && strings.HasPrefix(localStore.PublicURL(), "/") {
+		router.Static(localStore.PublicURL(), localStore.Root())
 	}
 
+	// category controllers
+	categoryRouter := router.Group("/categories")
+	{
+		categoryRouter.GET("", deps.ICategoryController.FindAll)
+		categoryRouter.GET("/:id", deps.ICategoryController.FindById)
+
+		categoryRouter.Use(deps.AuthMiddleware, middlewares.RequirePermission(models.PermissionCategoriesManage))
+		categoryRouter.POST("", deps.ICategoryController.Create)
+		categoryRouter.PUT("/:id", deps.ICategoryController.Update)
+		categoryRouter.DELETE("/:id", deps.ICategoryController.Delete)
+	}
+	router.GET("/tags", deps.ICategoryController.FindAllTags)
+
+	// public keys for services verifying access tokens
+	router.GET("/.well-known/jwks.json", deps.IAuthController.JWKS)
+
 	// auth controllers
 	authRouter := router.Group("/auth")
 	{
 		authRouter.POST("/register", deps.IAuthController.Register)
 		authRouter.POST("/login", deps.IAuthController.Login)
+		authRouter.POST("/login/mfa", deps.IAuthController.LoginMFA)
+		authRouter.POST("/refresh", deps.IAuthController.Refresh)
+		authRouter.POST("/logout", deps.AuthMiddleware, deps.IAuthController.Logout)
+		authRouter.POST("/logout-all", deps.AuthMiddleware, deps.IAuthController.LogoutAll)
+		authRouter.GET("/sessions", deps.AuthMiddleware, deps.IAuthController.Sessions)
+		authRouter.POST("/verify-email", deps.IAuthController.VerifyEmail)
+		authRouter.POST("/verify-email/resend", deps.AuthMiddleware, deps.IAuthController.ResendVerification)
+		authRouter.POST("/password-reset/request", deps.IAuthController.RequestPasswordReset)
+		authRouter.POST("/password-reset/confirm", deps.IAuthController.ConfirmPasswordReset)
+		authRouter.POST("/mfa/enroll", deps.AuthMiddleware, deps.IAuthController.BeginMFAEnrollment)
+		authRouter.POST("/mfa/enroll/confirm", deps.AuthMiddleware, deps.IAuthController.ConfirmMFAEnrollment)
+		authRouter.POST("/mfa/disable", deps.AuthMiddleware, deps.IAuthController.DisableMFA)
+		authRouter.GET("/oidc/:provider/start", deps.IAuthController.OIDCStart)
+		authRouter.GET("/oidc/:provider/callback", deps.IAuthController.OIDCCallback)
+	}
+
+	// API key controllers, managing keys needs a login, not a key
+	apiKeyRouter := router.Group("/api-keys")
+	{
+		apiKeyRouter.Use(deps.AuthMiddleware)
+		apiKeyRouter.POST("", deps.IAPIKeyController.Create)
+		apiKeyRouter.GET("", deps.IAPIKeyController.FindAll)
+		apiKeyRouter.DELETE("/:id", deps.IAPIKeyController.Revoke)
+	}
+
+	// admin controllers
+	adminRouter := router.Group("/admin")
+	{
+		adminRouter.Use(deps.AuthMiddleware, middlewares.RequirePermission(models.PermissionUsersManage))
+		adminRouter.PUT("/users/:id/role", deps.IUserController.UpdateRole)
+		adminRouter.POST("/users/:id/suspend", deps.IUserController.Suspend)
+		adminRouter.POST("/users/:id/unsuspend", deps.IUserController.Unsuspend)
+		adminRouter.POST("/users/:id/unlock", deps.IUserController.UnlockLogin)
 	}
 
 	// purchase controllers
 	purchaseRouter := router.Group("/purchases")
 	{
-		purchaseRouter.Use(deps.AuthMiddleware, deps.SessionMiddleware)
-		purchaseRouter.POST("", deps.IPurchaseController.Create)
+		purchaseRouter.Use(middlewares.AllowAPIKey(models.ScopePurchasesRead, models.ScopePurchasesWrite), deps.AuthMiddleware, deps.SessionMiddleware)
+		// clients retry purchases on flaky networks, the Idempotency-Key header makes that safe
+		purchaseRouter.POST("", middlewares.RequirePermission(models.PermissionPurchasesCreate), deps.IdempotencyMiddleware, deps.IPurchaseController.Create)
 		purchaseRouter.GET("", deps.IPurchaseController.FindAll)
 		purchaseRouter.GET("/:id", deps.IPurchaseController.FindById)
+		purchaseRouter.POST("/:id/cancel", deps.IPurchaseController.Cancel)
+	}
+
+	// cart controllers
+	cartRouter := router.Group("/cart")
+	{
+		cartRouter.Use(middlewares.AllowAPIKey(models.ScopeCartRead, models.ScopeCartWrite), deps.AuthMiddleware, deps.SessionMiddleware)
+		cartRouter.GET("", deps.ICartController.FindAll)
+		cartRouter.DELETE("", deps.ICartController.Clear)
+		cartRouter.POST("/items", deps.ICartController.Add)
+		cartRouter.PUT("/items/:itemId", deps.ICartController.Update)
+		cartRouter.DELETE("/items/:itemId", deps.ICartController.Remove)
+		cartRouter.POST("/checkout", middlewares.RequirePermission(models.PermissionPurchasesCreate), deps.ICartController.Checkout)
+	}
+
+	// order controllers
+	orderRouter := router.Group("/orders")
+	{
+		orderRouter.Use(middlewares.AllowAPIKey(models.ScopeOrdersRead, models.ScopeOrdersWrite), deps.AuthMiddleware, deps.SessionMiddleware)
+		orderRouter.GET("", deps.IOrderController.FindAll)
+		orderRouter.GET("/sales", deps.IOrderController.FindSales)
+		orderRouter.GET("/:id", deps.IOrderController.FindById)
+		orderRouter.GET("/:id/history", deps.IOrderController.FindHistory)
+		// seller
+		orderRouter.POST("/:id/ship", deps.IOrderController.Ship)
+		orderRouter.POST("/:id/deliver", deps.IOrderController.MarkDelivered)
+		// buyer
+		orderRouter.POST("/:id/confirm-receipt", deps.IOrderController.ConfirmReceipt)
+		// buyer within the cancellation window, seller before shipment
+		orderRouter.POST("/:id/cancel", deps.IOrderController.Cancel)
+	}
+
+	// waiting room, polled by clients holding a ticket
+	router.GET("/queue/status", deps.IQueueController.Status)
+
+	// webhook controllers
+	// called by external services, which authenticate with a signature instead of a user token
+	webhookRouter := router.Group("/webhooks")
+	{
+		webhookRouter.POST("/payments", deps.IPaymentWebhookController.Receive)
 	}
 
 	// Setup metrics endpoint
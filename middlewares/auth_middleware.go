@@ -1,9 +1,12 @@
 package middlewares
 
 import (
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"gin-freemarket/models"
 	"gin-freemarket/services"
 
 	"github.com/gin-gonic/gin"
@@ -11,7 +14,8 @@ import (
 
 // Auth Middleware
 // check if the user is authenticated based on JWT on Authorization header.
-func AuthMiddleware(authService services.IAuthService) gin.HandlerFunc {
+// "Authorization: ApiKey <key>" is accepted as well on route groups declaring their scopes with AllowAPIKey.
+func AuthMiddleware(authService services.IAuthService, apiKeyService services.IAPIKeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
 		if token == "" {
@@ -20,6 +24,11 @@ func AuthMiddleware(authService services.IAuthService) gin.HandlerFunc {
 			return
 		}
 
+		if key, ok := strings.CutPrefix(token, "ApiKey "); ok {
+			authenticateAPIKey(c, apiKeyService, key)
+			return
+		}
+
 		if !strings.HasPrefix(token, "Bearer ") {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort()
@@ -41,3 +50,58 @@ func AuthMiddleware(authService services.IAuthService) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func authenticateAPIKey(c *gin.Context, apiKeyService services.IAPIKeyService, rawKey string) {
+	// routes without AllowAPIKey, such as key management and logout, need a login
+	value, allowed := c.Get(apiKeyScopesKey)
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys are not accepted for this endpoint"})
+		c.Abort()
+		return
+	}
+
+	user, key, err := apiKeyService.Authenticate(rawKey)
+	if err != nil {
+		log.Println("API key authentication failed : ", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		c.Abort()
+		return
+	}
+
+	scope := value.(apiKeyScopes).forMethod(c.Request.Method)
+	if !key.HasScope(scope) {
+		log.Println("API key scope missing : Key ID = ", key.ID, ", Scope = ", scope)
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the scope " + string(scope)})
+		c.Abort()
+		return
+	}
+
+	c.Set("user", user)
+	c.Set("api_key", key)
+	// sessions of requests made with a key are tracked per key
+	c.Set("token", "api_key:"+strconv.FormatUint(uint64(key.ID), 10))
+	c.Next()
+}
+
+const apiKeyScopesKey = "api_key_scopes"
+
+type apiKeyScopes struct {
+	read  models.APIKeyScope
+	write models.APIKeyScope
+}
+
+func (s apiKeyScopes) forMethod(method string) models.APIKeyScope {
+	if method == http.MethodGet || method == http.MethodHead {
+		return s.read
+	}
+	return s.write
+}
+
+// AllowAPIKey lets API keys into the routes of a group: GET and HEAD requests need the read scope,
+// any other method the write scope. It must come before AuthMiddleware.
+func AllowAPIKey(read models.APIKeyScope, write models.APIKeyScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiKeyScopesKey, apiKeyScopes{read: read, write: write})
+		c.Next()
+	}
+}
@@ -0,0 +1,149 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gin-freemarket/models"
+	"gin-freemarket/utils/idempotency"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyKeyTTL = 24 * time.Hour
+	// maxIdempotentBodySize bounds the body read into memory for the fingerprint
+	maxIdempotentBodySize = 1 << 20 // 1MB
+)
+
+// Idempotency Middleware
+// a request with an Idempotency-Key header runs once per user and key. Retries within the TTL
+// (IDEMPOTENCY_KEY_TTL_HOURS, default 24) get the stored status and body back,
+// a retry arriving while the first request is still running gets 409.
+// Requests without the header are not affected. Must be used after AuthMiddleware.
+func IdempotencyMiddleware(store idempotency.Store) gin.HandlerFunc {
+	ttl := defaultIdempotencyKeyTTL
+	if hours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_KEY_TTL_HOURS")); err == nil && hours > 0 {
+		ttl = time.Duration(hours) * time.Hour
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body is too large"})
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys of different users never collide, and a key reused for another request is refused
+		scopedKey := fmt.Sprintf("%d:%s", user.(*models.User).ID, key)
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.FullPath()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		record, started, err := store.Begin(c.Request.Context(), scopedKey, fingerprint)
+		if err != nil {
+			log.Println("Failed to begin idempotent request : Key = ", scopedKey, ", Error = ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Issue on checking idempotency key"})
+			c.Abort()
+			return
+		}
+		if !started {
+			switch {
+			case record.Fingerprint != fingerprint:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case record.State == idempotency.StateInFlight:
+				c.JSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still being processed"})
+			default:
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Data(record.StatusCode, record.ContentType, record.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		completed := false
+		defer func() {
+			// a panic or a server error gives the key back, so the client can retry
+			if !completed {
+				if err := store.Release(context.Background(), scopedKey, record.Owner); err != nil {
+					log.Println("Failed to release idempotency key : Key = ", scopedKey, ", Error = ", err)
+				}
+			}
+		}()
+
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			return
+		}
+		err = store.Complete(context.Background(), scopedKey, record.Owner, idempotency.Record{
+			Fingerprint: fingerprint,
+			StatusCode:  recorder.Status(),
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}, ttl)
+		if err != nil {
+			log.Println("Failed to store idempotent response : Key = ", scopedKey, ", Error = ", err)
+			// the lock expired and the key may belong to a newer request by now, leave it alone
+			completed = errors.Is(err, idempotency.ErrKeyNotHeld)
+			return
+		}
+		completed = true
+	}
+}
+
+// responseRecorder copies the response body while it is written to the client.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"log"
+	"net/http"
+
+	"gin-freemarket/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permission Middleware
+// allow only users having all of the permissions, taken from the role claim of the JWT.
+// must be used after AuthMiddleware, which sets the user to context.
+func RequirePermission(permissions ...models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		for _, permission := range permissions {
+			if !user.(*models.User).HasPermission(permission) {
+				log.Println("Permission denied : User ID = ", user.(*models.User).ID, ", Permission = ", permission)
+				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -3,40 +3,58 @@ package middlewares
 import (
 	"log"
 	"net/http"
+	"strconv"
 
-	"gin-freemarket/services"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
 	"gin-freemarket/utils/sessions"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SessionMiddleware(authService services.IAuthService) gin.HandlerFunc {
-	sessionManager, err := sessions.GetSessionManager()
-	if err != nil {
-		log.Fatal(err)
-	}
+func SessionMiddleware(sessionManager sessions.ISessionManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token, exists := c.Get("token")
 		if !exists || token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Issue on getting token"})
+			c.Abort()
 			return
 		}
 
-		exists, err := sessionManager.SessionExists(token.(string))
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		session := sessions.Session{
+			Token:     token.(string),
+			UserID:    user.(*models.User).ID,
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}
+
+		// every request extends the session
+		alive, err := sessionManager.TouchSession(session)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Issue on checking session"})
+			c.Abort()
 			return
 		}
 
-		if !exists {
-			// need to register new session
-			ok, err := sessionManager.RegisterSession(token.(string))
+		if !alive {
+			// need to register new session, or to wait for one in the waiting room
+			ticket, err := sessionManager.RequestSession(session)
 			if err != nil {
+				log.Println("Request session failed : ", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Issue on registering session"})
+				c.Abort()
 				return
 			}
-			if !ok {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Session limit reached"})
+			if ticket != nil {
+				c.Header("Retry-After", strconv.Itoa(int(sessions.QueuePollInterval.Seconds())))
+				c.JSON(http.StatusServiceUnavailable, dto.ToQueueStatusResponse(ticket))
+				c.Abort()
 				return
 			}
 		}
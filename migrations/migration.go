@@ -1,8 +1,13 @@
 package main
 
 import (
+	"os"
+	"strconv"
+	"strings"
+
 	"gin-freemarket/infra"
 	"gin-freemarket/models"
+	"gin-freemarket/repositories"
 
 	"gorm.io/gorm"
 )
@@ -11,27 +16,175 @@ func main() {
 	db := infra.SetupDB()
 
 	// // Delete existing tables
+	// db.Migrator().DropTable(&models.OrderStatusHistory{})
+	// db.Migrator().DropTable(&models.OrderLine{})
+	// db.Migrator().DropTable(&models.Order{})
+	// db.Migrator().DropTable(&models.CartItem{})
 	// db.Migrator().DropTable(&models.Purchase{})
+	// db.Migrator().DropTable(&models.ItemImage{})
+	// db.Migrator().DropTable("item_tags")
 	// db.Migrator().DropTable(&models.Item{})
+	// db.Migrator().DropTable(&models.Tag{})
+	// db.Migrator().DropTable(&models.Category{})
 	// db.Migrator().DropTable(&models.User{})
 
 	// Control migration order
 	err := db.Transaction(func(tx *gorm.DB) error {
 		// 1. User table
+		// users registered before email verification existed keep selling, they count as verified
+		grandfatherVerification := tx.Migrator().HasTable(&models.User{}) && !tx.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
 		if err := tx.AutoMigrate(&models.User{}); err != nil {
 			return err
 		}
+		if grandfatherVerification {
+			if err := tx.Exec("UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL").Error; err != nil {
+				return err
+			}
+		}
+
+		// 1-0. Users listed in ADMIN_USER_IDS, which used to grant admin rights, become admins.
+		// This runs once, later role changes are made through the role endpoints and must not be undone.
+		if err := tx.AutoMigrate(&models.DataMigration{}); err != nil {
+			return err
+		}
+		if err := runOnce(tx, "grant_admin_user_ids", func() error {
+			adminIDs := parseAdminUserIDs(os.Getenv("ADMIN_USER_IDS"))
+			if len(adminIDs) == 0 {
+				return nil
+			}
+			return tx.Model(&models.User{}).Where("id IN ?", adminIDs).Update("role", models.RoleAdmin).Error
+		}); err != nil {
+			return err
+		}
+
+		// 1-1. Refresh tokens and emailed single-use tokens of users
+		if err := tx.AutoMigrate(&models.RefreshToken{}, &models.ActionToken{}); err != nil {
+			return err
+		}
+
+		// 1-2. Two-factor authentication
+		if err := tx.AutoMigrate(&models.UserMFA{}, &models.MFARecoveryCode{}); err != nil {
+			return err
+		}
+
+		// 1-3. Accounts at OpenID Connect providers linked to users
+		if err := tx.AutoMigrate(&models.UserIdentity{}); err != nil {
+			return err
+		}
+
+		// 1-4. Personal API keys
+		if err := tx.AutoMigrate(&models.APIKey{}); err != nil {
+			return err
+		}
+
+		// 1-5. Sessions, waiting room and access token denylists of the Postgres session store
+		if err := tx.AutoMigrate(&models.UserSession{}, &models.SessionTicket{}, &models.RevokedAccessToken{}, &models.UserTokenCutoff{}); err != nil {
+			return err
+		}
+
+		// 2. Category and Tag tables, referenced by items
+		// categories references itself through parent_id
+		// the slug index used to cover deleted categories as well, it is replaced by a partial one
+		if tx.Migrator().HasIndex(&models.Category{}, "idx_categories_slug") {
+			if err := tx.Migrator().DropIndex(&models.Category{}, "idx_categories_slug"); err != nil {
+				return err
+			}
+		}
+		if err := tx.AutoMigrate(&models.Category{}); err != nil {
+			return err
+		}
+		if err := tx.AutoMigrate(&models.Tag{}); err != nil {
+			return err
+		}
 
-		// 2. Item table
+		// 3. Item table
+		// the item_tags join table is created here, so tags must already exist
 		if err := tx.AutoMigrate(&models.Item{}); err != nil {
 			return err
 		}
 
-		// 3. Purchase table (including foreign key constraints)
+		// 3-1. Full-text search column on items
+		// search_vector is maintained by PostgreSQL, so it is not part of models.Item
+		if err := tx.Exec(`ALTER TABLE items ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('` + repositories.TextSearchConfig + `', coalesce(name, '')), 'A') ||
+				setweight(to_tsvector('` + repositories.TextSearchConfig + `', coalesce(description, '')), 'B')
+			) STORED`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_items_search_vector ON items USING GIN (search_vector)").Error; err != nil {
+			return err
+		}
+
+		// 3-2. Item image table
+		if err := tx.AutoMigrate(&models.ItemImage{}); err != nil {
+			return err
+		}
+
+		// 4. Purchase table (including foreign key constraints)
 		if err := tx.AutoMigrate(&models.Purchase{}); err != nil {
 			return err
 		}
 
+		// 5. Cart table
+		if err := tx.AutoMigrate(&models.CartItem{}); err != nil {
+			return err
+		}
+
+		// 6. Order tables, order lines reference orders and items
+		if err := tx.AutoMigrate(&models.Order{}, &models.OrderLine{}); err != nil {
+			return err
+		}
+
+		// 6-1. Order status history, append-only
+		if err := tx.AutoMigrate(&models.OrderStatusHistory{}); err != nil {
+			return err
+		}
+		if err := tx.Exec(`CREATE OR REPLACE FUNCTION reject_order_status_history_change() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'order_status_history is append-only';
+			END;
+			$$ LANGUAGE plpgsql`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`DROP TRIGGER IF EXISTS order_status_history_append_only ON order_status_history`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`CREATE TRIGGER order_status_history_append_only
+			BEFORE UPDATE OR DELETE ON order_status_history
+			FOR EACH ROW EXECUTE FUNCTION reject_order_status_history_change()`).Error; err != nil {
+			return err
+		}
+
+		// 6-2. Payment webhook events, one row per provider event ID
+		if err := tx.AutoMigrate(&models.ProcessedWebhookEvent{}); err != nil {
+			return err
+		}
+
+		// 6-3. Idempotency keys, used when Redis is not available
+		if err := tx.AutoMigrate(&models.IdempotencyKey{}); err != nil {
+			return err
+		}
+
+		// 6-4. Payment operations sent to the provider after the order change is committed
+		if err := tx.AutoMigrate(&models.PaymentOperation{}); err != nil {
+			return err
+		}
+
+		// 7. Data migration: every purchase becomes a completed single-line order.
+		// legacy_purchase_id makes this step safe to run again.
+		if err := migratePurchasesToOrders(tx); err != nil {
+			return err
+		}
+
+		// 8. Orders without history get their current status as the first entry
+		if err := tx.Exec(`INSERT INTO order_status_history (order_id, from_status, to_status, note, created_at)
+			SELECT o.id, '', o.status, 'recorded by migration', o.created_at
+			FROM orders o
+			WHERE NOT EXISTS (SELECT 1 FROM order_status_history h WHERE h.order_id = o.id)`).Error; err != nil {
+			return err
+		}
+
 		return nil
 	})
 
@@ -39,3 +192,49 @@ func main() {
 		panic("Failed to migrate database: " + err.Error())
 	}
 }
+
+// migratePurchasesToOrders copies purchases that have no order yet into orders and order lines.
+// Purchases had no tax or shipping, so the subtotal and the total are the purchase total.
+func migratePurchasesToOrders(tx *gorm.DB) error {
+	if err := tx.Exec(`INSERT INTO orders
+			(order_number, user_id, status, subtotal, tax, shipping_fee, discount, total_price, legacy_purchase_id, created_at, updated_at)
+		SELECT 'PUR-' || lpad(p.id::text, 10, '0'), p.user_id, ?, p.total_price, 0, 0, 0, p.total_price, p.id, p.created_at, p.updated_at
+		FROM purchases p
+		WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.legacy_purchase_id = p.id)`,
+		models.OrderStatusCompleted).Error; err != nil {
+		return err
+	}
+
+	return tx.Exec(`INSERT INTO order_lines (order_id, item_id, price, quantity, total_price, created_at)
+		SELECT o.id, p.item_id, p.price, p.quantity, p.total_price, p.created_at
+		FROM purchases p JOIN orders o ON o.legacy_purchase_id = p.id
+		WHERE NOT EXISTS (SELECT 1 FROM order_lines l WHERE l.order_id = o.id)`).Error
+}
+
+// runOnce runs a data migration unless it is recorded as applied, and records it.
+func runOnce(tx *gorm.DB, name string, migrate func() error) error {
+	var applied int64
+	if err := tx.Model(&models.DataMigration{}).Where("name = ?", name).Count(&applied).Error; err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+	if err := migrate(); err != nil {
+		return err
+	}
+	return tx.Create(&models.DataMigration{Name: name}).Error
+}
+
+// parseAdminUserIDs reads the comma separated user IDs of the former ADMIN_USER_IDS setting.
+func parseAdminUserIDs(value string) []uint {
+	var ids []uint
+	for _, id := range strings.Split(value, ",") {
+		userID, err := strconv.ParseUint(strings.TrimSpace(id), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint(userID))
+	}
+	return ids
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type ActionTokenPurpose string
+
+const (
+	ActionTokenVerifyEmail   ActionTokenPurpose = "verify_email"
+	ActionTokenPasswordReset ActionTokenPurpose = "password_reset"
+	// ActionTokenMFAChallenge is returned by the password step of a login with 2FA enabled
+	ActionTokenMFAChallenge ActionTokenPurpose = "mfa_challenge"
+)
+
+// ActionToken records a signed token sent by email or handed out during login, so that it can be used only once.
+// The token itself is not stored, Nonce is the random part it carries.
+type ActionToken struct {
+	ID        uint               `gorm:"primaryKey"`
+	UserID    uint               `gorm:"not null;index"`
+	Purpose   ActionTokenPurpose `gorm:"type:varchar(32);not null"`
+	Nonce     string             `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time          `gorm:"not null;index"`
+	UsedAt    *time.Time
+	// FailedAttempts counts wrong codes entered for an MFA challenge, too many of them use it up
+	FailedAttempts int `gorm:"not null;default:0"`
+	CreatedAt      time.Time
+	User           User `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// APIKeyScope limits what an API key can be used for. Each route group accepting API keys
+// requires one scope for reading and one for writing.
+type APIKeyScope string
+
+const (
+	ScopeItemsRead      APIKeyScope = "items:read"
+	ScopeItemsWrite     APIKeyScope = "items:write"
+	ScopePurchasesRead  APIKeyScope = "purchases:read"
+	ScopePurchasesWrite APIKeyScope = "purchases:write"
+	ScopeCartRead       APIKeyScope = "cart:read"
+	ScopeCartWrite      APIKeyScope = "cart:write"
+	ScopeOrdersRead     APIKeyScope = "orders:read"
+	ScopeOrdersWrite    APIKeyScope = "orders:write"
+)
+
+// APIKey is a long-lived credential a user creates for scripts. Only the SHA-256 hash of the key is stored,
+// Prefix is kept in clear so that the user can tell the keys apart.
+// A key never grants more than the permissions of its user.
+type APIKey struct {
+	ID         uint          `gorm:"primaryKey"`
+	UserID     uint          `gorm:"not null;index"`
+	Name       string        `gorm:"not null"`
+	Prefix     string        `gorm:"type:varchar(16);not null"`
+	KeyHash    string        `gorm:"uniqueIndex;not null"`
+	Scopes     []APIKeyScope `gorm:"serializer:json;type:jsonb;not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	User       User `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+func (k *APIKey) HasScope(scope APIKeyScope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type CartItem struct {
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"not null;uniqueIndex:idx_cart_items_user_item"`
+	ItemID    uint `gorm:"not null;uniqueIndex:idx_cart_items_user_item"`
+	Quantity  uint `gorm:"not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	User      User `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+	Item      Item `gorm:"foreignKey:ItemID;references:ID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "gorm.io/gorm"
+
+type Category struct {
+	gorm.Model
+	Name string `gorm:"not null"`
+	// Slug is unique among categories that are not deleted, so a deleted slug can be reused
+	Slug     string     `gorm:"uniqueIndex:idx_categories_slug_active,where:deleted_at IS NULL;not null"`
+	ParentID *uint      `gorm:"index"`
+	Parent   *Category  `gorm:"foreignKey:ParentID;references:ID"`
+	Children []Category `gorm:"foreignKey:ParentID;references:ID"`
+}
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// DataMigration records a one-time data migration as applied, see migrations/migration.go.
+type DataMigration struct {
+	Name      string    `gorm:"primaryKey"`
+	AppliedAt time.Time `gorm:"autoCreateTime"`
+}
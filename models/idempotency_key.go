@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// IdempotencyKey is the Postgres fallback storage of idempotency keys when Redis is not available.
+// Key is scoped by user, see middlewares.IdempotencyMiddleware.
+type IdempotencyKey struct {
+	Key string `gorm:"primaryKey"`
+	// Owner is the token of the request holding the key
+	Owner       string `gorm:"not null;default:''"`
+	Fingerprint string `gorm:"not null"`
+	State       string `gorm:"type:varchar(32);not null"`
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	// ExpiresAt is the end of the lock while in flight, and the end of the replay period once completed
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -5,9 +5,13 @@ import "gorm.io/gorm"
 type Item struct {
 	gorm.Model
 	Name        string `gorm:"not null"`
-	Price       uint   `gorm:"not null"`
+	Price       uint   `gorm:"not null;index"`
 	Description string
-	SoldOut     bool `gorm:"not null; default:false"`
-	Quantity    uint `gorm:"not null; default:1"`
-	UserID      uint `gorm:"not null"`
+	SoldOut     bool        `gorm:"not null; default:false"`
+	Quantity    uint        `gorm:"not null; default:1"`
+	UserID      uint        `gorm:"not null;index"`
+	CategoryID  *uint       `gorm:"index"`
+	Category    *Category   `gorm:"constraint:OnDelete:SET NULL;foreignKey:CategoryID"`
+	Tags        []Tag       `gorm:"many2many:item_tags;constraint:OnDelete:CASCADE"`
+	Images      []ItemImage `gorm:"constraint:OnDelete:CASCADE;foreignKey:ItemID"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type ItemImage struct {
+	ID           uint   `gorm:"primaryKey"`
+	ItemID       uint   `gorm:"not null;index"`
+	Key          string `gorm:"not null"`
+	ThumbnailKey string `gorm:"not null"`
+	ContentType  string `gorm:"not null"`
+	Size         int64  `gorm:"not null"`
+	Width        int    `gorm:"not null"`
+	Height       int    `gorm:"not null"`
+	Position     int    `gorm:"not null;default:0"`
+	CreatedAt    time.Time
+	// URL and ThumbnailURL are resolved from the keys by the blob store when the item is returned
+	URL          string `gorm:"-"`
+	ThumbnailURL string `gorm:"-"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+type OrderStatus string
+
+const (
+	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCompleted OrderStatus = "completed"
+	OrderStatusCancelled OrderStatus = "cancelled"
+)
+
+// Order is one checkout of a user. Amounts are in the same unit as Item.Price.
+// TotalPrice = Subtotal + Tax + ShippingFee - Discount
+type Order struct {
+	ID          uint        `gorm:"primaryKey"`
+	OrderNumber string      `gorm:"uniqueIndex;not null"`
+	UserID      uint        `gorm:"not null;index"`
+	Status      OrderStatus `gorm:"type:varchar(32);not null;index"`
+	Subtotal    int         `gorm:"not null"`
+	Tax         int         `gorm:"not null;default:0"`
+	ShippingFee int         `gorm:"not null;default:0"`
+	Discount    int         `gorm:"not null;default:0"`
+	TotalPrice  int         `gorm:"not null"`
+	// PaymentIntentID and PaymentStatus mirror the payment at the payment provider
+	PaymentIntentID string `gorm:"index"`
+	PaymentStatus   string `gorm:"type:varchar(32)"`
+	// TrackingNumber is set by the seller when the order is shipped
+	TrackingNumber string
+	ShippedAt      *time.Time
+	DeliveredAt    *time.Time
+	CompletedAt    *time.Time
+	CancelledAt    *time.Time
+	CancelledBy    *uint
+	CancelReason   string
+	// LegacyPurchaseID is the purchases row this order was migrated from
+	LegacyPurchaseID *uint `gorm:"uniqueIndex"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	User             User        `gorm:"foreignKey:UserID;references:ID"`
+	Lines            []OrderLine `gorm:"foreignKey:OrderID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+type OrderLine struct {
+	ID         uint `gorm:"primaryKey"`
+	OrderID    uint `gorm:"not null;index"`
+	ItemID     uint `gorm:"not null;index"`
+	Price      int  `gorm:"not null"`
+	Quantity   int  `gorm:"not null"`
+	TotalPrice int  `gorm:"not null"`
+	CreatedAt  time.Time
+	Order      *Order `gorm:"foreignKey:OrderID;references:ID"`
+	Item       Item   `gorm:"foreignKey:ItemID;references:ID"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// OrderStatusHistory records every status change of an order. Rows are only ever inserted.
+type OrderStatusHistory struct {
+	ID         uint        `gorm:"primaryKey"`
+	OrderID    uint        `gorm:"not null;index"`
+	FromStatus OrderStatus `gorm:"type:varchar(32)"`
+	ToStatus   OrderStatus `gorm:"type:varchar(32);not null"`
+	// ChangedBy is the user who changed the status, nil for changes made by the system
+	ChangedBy *uint `gorm:"index"`
+	Note      string
+	CreatedAt time.Time
+	Order     Order `gorm:"foreignKey:OrderID;references:ID;constraint:OnDelete:RESTRICT"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
+}
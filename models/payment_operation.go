@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+type PaymentAction string
+
+const (
+	// PaymentActionAuthorize holds the total of a placed order, the order is paid once it is done
+	PaymentActionAuthorize PaymentAction = "authorize"
+	PaymentActionCapture   PaymentAction = "capture"
+	PaymentActionVoid      PaymentAction = "void"
+	PaymentActionRefund    PaymentAction = "refund"
+)
+
+type PaymentOperationStatus string
+
+const (
+	PaymentOperationPending PaymentOperationStatus = "pending"
+	PaymentOperationDone    PaymentOperationStatus = "done"
+	// PaymentOperationFailed operations ran out of retries and need a look by an operator
+	PaymentOperationFailed PaymentOperationStatus = "failed"
+)
+
+// PaymentOperation is a call to the payment provider decided by an order transaction.
+// It is written in the same transaction as the order change and sent to the provider after the commit,
+// so that money only moves for committed order changes.
+type PaymentOperation struct {
+	ID      uint          `gorm:"primaryKey"`
+	OrderID uint          `gorm:"not null;index"`
+	Action  PaymentAction `gorm:"type:varchar(16);not null"`
+	// IdempotencyKey is sent with every attempt, the provider applies the operation once
+	IdempotencyKey string                 `gorm:"uniqueIndex;not null"`
+	Status         PaymentOperationStatus `gorm:"type:varchar(32);not null;index"`
+	Attempts       int                    `gorm:"not null;default:0"`
+	LastError      string
+	NextAttemptAt  *time.Time `gorm:"index"`
+	ProcessedAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
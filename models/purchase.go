@@ -4,6 +4,9 @@ import (
 	"time"
 )
 
+// Purchase is the single item purchase record used before orders were introduced.
+// It is kept as the source of the data migration to orders, new purchases are stored as orders.
+// Deprecated: use Order and OrderLine.
 type Purchase struct {
 	ID         uint `gorm:"primaryKey"`
 	UserID     uint `gorm:"not null;index"`
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// RefreshToken is an opaque, single-use refresh token. Only the SHA-256 hash of the token is stored.
+// Every refresh replaces the token with a new one of the same family; presenting a replaced token
+// again means it was stolen, and the whole family is revoked.
+type RefreshToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	FamilyID  string    `gorm:"not null;index"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	// RotatedAt is set when the token was exchanged for ReplacedByID
+	RotatedAt    *time.Time
+	ReplacedByID *uint
+	RevokedAt    *time.Time
+	CreatedAt    time.Time
+	User         User `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "slices"
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleSeller Role = "seller"
+	RoleBuyer  Role = "buyer"
+)
+
+type Permission string
+
+const (
+	PermissionItemsCreate      Permission = "items:create"
+	PermissionItemsManageAny   Permission = "items:manage_any"
+	PermissionPurchasesCreate  Permission = "purchases:create"
+	PermissionCategoriesManage Permission = "categories:manage"
+	PermissionUsersManage      Permission = "users:manage"
+)
+
+// rolePermissions lists what each role may do. Reading is open to everyone and not listed.
+var rolePermissions = map[Role][]Permission{
+	RoleBuyer:  {PermissionPurchasesCreate},
+	RoleSeller: {PermissionPurchasesCreate, PermissionItemsCreate},
+	RoleAdmin: {PermissionPurchasesCreate, PermissionItemsCreate, PermissionItemsManageAny,
+		PermissionCategoriesManage, PermissionUsersManage},
+}
+
+// suspendedPermissions are taken away from suspended users
+var suspendedPermissions = []Permission{PermissionItemsCreate, PermissionPurchasesCreate}
+
+// unverifiedPermissions are taken away from users who have not verified their email address
+var unverifiedPermissions = []Permission{PermissionItemsCreate}
+
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// PermissionsFor returns the permissions of the role, without those a suspension or
+// a missing email verification takes away.
+func PermissionsFor(role Role, suspended bool, emailVerified bool) []Permission {
+	var permissions []Permission
+	for _, permission := range rolePermissions[role] {
+		if suspended && slices.Contains(suspendedPermissions, permission) {
+			continue
+		}
+		if !emailVerified && slices.Contains(unverifiedPermissions, permission) {
+			continue
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions
+}
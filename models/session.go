@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// UserSession is a session slot of the Postgres session store, see sessions.PostgresSessionManager.
+type UserSession struct {
+	Token      string `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// ExpiresAt slides with every request
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// SessionTicket is a place in the waiting room of the Postgres session store, ID gives the arrival order.
+type SessionTicket struct {
+	ID        uint   `gorm:"primaryKey"`
+	TicketID  string `gorm:"not null;uniqueIndex"`
+	Token     string `gorm:"not null;uniqueIndex"`
+	UserID    uint   `gorm:"not null"`
+	IP        string
+	UserAgent string
+	Status    string `gorm:"type:varchar(32);not null;index"`
+	// ExpiresAt is extended by polling while waiting, and lasts as long as a session once admitted
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+// RevokedAccessToken is a logged out access token, kept until the token would have expired.
+type RevokedAccessToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// UserTokenCutoff rejects every access token of the user issued until RevokedBefore.
+type UserTokenCutoff struct {
+	UserID        uint      `gorm:"primaryKey;autoIncrement:false"`
+	RevokedBefore time.Time `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null;index"`
+}
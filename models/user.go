@@ -1,10 +1,33 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
 	Email    string `gorm:"uniqueIndex;not null"`
 	Password string `gorm:"not null"`
-	Items    []Item `gorm:"constraint:OnDelete:CASCADE;foreignKey:UserID"`
+	// every user can sell and buy unless the role says otherwise
+	Role Role `gorm:"type:varchar(32);not null;default:'seller'"`
+	// SuspendedAt is set while an admin has suspended the user
+	SuspendedAt *time.Time
+	// EmailVerifiedAt is set once the user proved to own the email address, only verified users may sell
+	EmailVerifiedAt *time.Time
+	Items           []Item `gorm:"constraint:OnDelete:CASCADE;foreignKey:UserID"`
+	// Permissions are the permissions of the authenticated user, taken from the JWT
+	Permissions []Permission `gorm:"-"`
+}
+
+func (u *User) HasPermission(permission Permission) bool {
+	return slices.Contains(u.Permissions, permission)
+}
+
+// GrantedPermissions resolves the permissions of the role against the account state,
+// it is used to fill the permissions claim of the JWT.
+func (u *User) GrantedPermissions() []Permission {
+	return PermissionsFor(u.Role, u.SuspendedAt != nil, u.EmailVerifiedAt != nil)
 }
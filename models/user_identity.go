@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an OpenID Connect provider.
+// Subject is the provider's stable ID of the account, the email address may change.
+type UserIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Provider  string `gorm:"type:varchar(64);not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject   string `gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Email     string
+	CreatedAt time.Time
+	User      User `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+}
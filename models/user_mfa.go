@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// UserMFA is the TOTP second factor of a user. It is pending until the first code is confirmed.
+type UserMFA struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint `gorm:"uniqueIndex;not null"`
+	// SecretEncrypted is the base32 TOTP secret encrypted with TOTP_ENCRYPTION_KEY
+	SecretEncrypted string `gorm:"not null"`
+	EnabledAt       *time.Time
+	// LastCounter is the time step of the last accepted code, a code is never accepted twice
+	LastCounter int64 `gorm:"not null;default:0"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	User        User `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+func (UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+// MFARecoveryCode is a one-time code replacing a TOTP code when the authenticator is lost.
+// Only the SHA-256 hash is stored.
+type MFARecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+	User      User `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE"`
+}
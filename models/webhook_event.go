@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+type WebhookEventStatus string
+
+const (
+	// WebhookEventPending events could not be applied yet and are retried
+	WebhookEventPending   WebhookEventStatus = "pending"
+	WebhookEventProcessed WebhookEventStatus = "processed"
+	// WebhookEventIgnored events were valid but had nothing to change
+	WebhookEventIgnored WebhookEventStatus = "ignored"
+	// WebhookEventFailed events ran out of retries and need a look by an operator
+	WebhookEventFailed WebhookEventStatus = "failed"
+)
+
+// ProcessedWebhookEvent stores every payment webhook received, keyed by the provider's event ID,
+// so that redelivered events are applied only once.
+type ProcessedWebhookEvent struct {
+	ID              uint               `gorm:"primaryKey"`
+	EventID         string             `gorm:"uniqueIndex;not null"`
+	Type            string             `gorm:"type:varchar(64);not null"`
+	PaymentIntentID string             `gorm:"index"`
+	Payload         string             `gorm:"type:text;not null"`
+	Status          WebhookEventStatus `gorm:"type:varchar(32);not null;index"`
+	Attempts        int                `gorm:"not null;default:0"`
+	LastError       string
+	NextAttemptAt   *time.Time `gorm:"index"`
+	ProcessedAt     *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+func (ProcessedWebhookEvent) TableName() string {
+	return "processed_webhook_events"
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"gin-freemarket/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type IAPIKeyRepository interface {
+	Create(key *models.APIKey) error
+	FindAll(userID uint) ([]models.APIKey, error)
+	FindByHash(keyHash string) (*models.APIKey, error)
+	Revoke(userID uint, id uint, now time.Time) error
+	TouchLastUsed(id uint, now time.Time, interval time.Duration) error
+}
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) IAPIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) Create(key *models.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+// FindAll returns the keys of the user, revoked ones included, newest first.
+func (r *APIKeyRepository) FindAll(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := r.db.Where("user_id = ?", userID).Order("id DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *APIKeyRepository) FindByHash(keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.Preload("User").Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Revoke revokes a key of the user, gorm.ErrRecordNotFound when the user has no such active key.
+func (r *APIKeyRepository) Revoke(userID uint, id uint, now time.Time) error {
+	result := r.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchLastUsed records the use of the key, at most once per interval so that busy scripts do not write on every request.
+func (r *APIKeyRepository) TouchLastUsed(id uint, now time.Time, interval time.Duration) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-interval)).
+		Update("last_used_at", now).Error
+}
@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"gin-freemarket/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ICartRepository interface {
+	FindAll(userID uint) ([]models.CartItem, error)
+	Add(userID uint, itemID uint, quantity uint) error
+	SetQuantity(userID uint, itemID uint, quantity uint) error
+	Remove(userID uint, itemID uint) error
+	Clear(userID uint) error
+}
+
+type CartRepository struct {
+	db *gorm.DB
+}
+
+func NewCartRepository(db *gorm.DB) ICartRepository {
+	return &CartRepository{db: db}
+}
+
+// FindAll returns the cart of the user ordered by item ID
+func (r *CartRepository) FindAll(userID uint) ([]models.CartItem, error) {
+	var cartItems []models.CartItem
+	err := r.db.Preload("Item").Where("user_id = ?", userID).Order("item_id").Find(&cartItems).Error
+	return cartItems, err
+}
+
+// Add puts the item in the cart, or increases its quantity when it is already there
+func (r *CartRepository) Add(userID uint, itemID uint, quantity uint) error {
+	cartItem := models.CartItem{UserID: userID, ItemID: itemID, Quantity: quantity}
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "item_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   gorm.Expr("cart_items.quantity + EXCLUDED.quantity"),
+			"updated_at": gorm.Expr("EXCLUDED.updated_at"),
+		}),
+	}).Create(&cartItem).Error
+}
+
+func (r *CartRepository) SetQuantity(userID uint, itemID uint, quantity uint) error {
+	result := r.db.Model(&models.CartItem{}).Where("user_id = ? AND item_id = ?", userID, itemID).Update("quantity", quantity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *CartRepository) Remove(userID uint, itemID uint) error {
+	result := r.db.Where("user_id = ? AND item_id = ?", userID, itemID).Delete(&models.CartItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *CartRepository) Clear(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.CartItem{}).Error
+}
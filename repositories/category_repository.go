@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"gin-freemarket/models"
+
+	"gorm.io/gorm"
+)
+
+type ICategoryRepository interface {
+	FindAll() ([]models.Category, error)
+	FindById(id uint) (*models.Category, error)
+	FindDescendantIDs(id uint) ([]uint, error)
+	Create(category *models.Category) error
+	Update(category *models.Category) error
+	Delete(id uint) error
+}
+
+type CategoryRepository struct {
+	db *gorm.DB
+}
+
+func NewCategoryRepository(db *gorm.DB) ICategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+func (r *CategoryRepository) FindAll() ([]models.Category, error) {
+	var categories []models.Category
+	if err := r.db.Order("parent_id NULLS FIRST, name").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (r *CategoryRepository) FindById(id uint) (*models.Category, error) {
+	var category models.Category
+	if err := r.db.Preload("Children").First(&category, id).Error; err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// FindDescendantIDs returns the ID of the category and of every category below it.
+func (r *CategoryRepository) FindDescendantIDs(id uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.Raw(categorySubtreeSQL, id).Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *CategoryRepository) Create(category *models.Category) error {
+	return r.db.Create(category).Error
+}
+
+func (r *CategoryRepository) Update(category *models.Category) error {
+	return r.db.Model(category).Select("Name", "Slug", "ParentID").Updates(category).Error
+}
+
+func (r *CategoryRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// soft delete keeps the row, so the ON DELETE SET NULL constraint does not fire
+		if err := tx.Model(&models.Item{}).Where("category_id = ?", id).Update("category_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Category{}, id).Error
+	})
+}
+
+// categorySubtreeSQL selects the IDs of a category subtree, the root category ID is the only parameter.
+const categorySubtreeSQL = `WITH RECURSIVE subtree AS (
+	SELECT id FROM categories WHERE id = ? AND deleted_at IS NULL
+	UNION ALL
+	SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id WHERE c.deleted_at IS NULL
+) SELECT id FROM subtree`
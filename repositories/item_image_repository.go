@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"gin-freemarket/models"
+
+	"gorm.io/gorm"
+)
+
+type IItemImageRepository interface {
+	FindByItemID(itemID uint) ([]models.ItemImage, error)
+	FindById(itemID uint, id uint) (*models.ItemImage, error)
+	Create(image *models.ItemImage) error
+	Delete(id uint) error
+	UpdatePositions(itemID uint, imageIDs []uint) error
+}
+
+type ItemImageRepository struct {
+	db *gorm.DB
+}
+
+func NewItemImageRepository(db *gorm.DB) IItemImageRepository {
+	return &ItemImageRepository{db: db}
+}
+
+func (r *ItemImageRepository) FindByItemID(itemID uint) ([]models.ItemImage, error) {
+	var images []models.ItemImage
+	if err := r.db.Where("item_id = ?", itemID).Order("position, id").Find(&images).Error; err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (r *ItemImageRepository) FindById(itemID uint, id uint) (*models.ItemImage, error) {
+	var image models.ItemImage
+	if err := r.db.Where("item_id = ? AND id = ?", itemID, id).First(&image).Error; err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// Create appends the image after the existing images of the item.
+func (r *ItemImageRepository) Create(image *models.ItemImage) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var position int
+		if err := tx.Model(&models.ItemImage{}).
+			Where("item_id = ?", image.ItemID).
+			Select("COALESCE(MAX(position) + 1, 0)").
+			Scan(&position).Error; err != nil {
+			return err
+		}
+		image.Position = position
+		return tx.Create(image).Error
+	})
+}
+
+func (r *ItemImageRepository) Delete(id uint) error {
+	return r.db.Delete(&models.ItemImage{}, id).Error
+}
+
+// UpdatePositions sets the position of every image to its index in imageIDs.
+func (r *ItemImageRepository) UpdatePositions(itemID uint, imageIDs []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for position, id := range imageIDs {
+			if err := tx.Model(&models.ItemImage{}).
+				Where("item_id = ? AND id = ?", itemID, id).
+				Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -2,16 +2,89 @@ package repositories
 
 import (
 	"errors"
+	"fmt"
 	"gin-freemarket/models"
+	"strings"
 
 	"gorm.io/gorm"
 )
 
+// ItemQuery carries filter, sort and paging options for IItemRepository.Search.
+// Nil filters are not applied.
+type ItemQuery struct {
+	MinPrice    *uint
+	MaxPrice    *uint
+	UserID      *uint
+	SoldOut     *bool
+	MinQuantity *uint
+	// CategoryID matches items in the category subtree
+	CategoryID *uint
+	// Tags matches items carrying all of the tags
+	Tags []string
+
+	// SortBy is one of "price", "created_at" or "name". The item ID is always used as tie breaker.
+	SortBy string
+	Desc   bool
+
+	Limit  int
+	Offset int
+	// After switches to keyset pagination and returns rows strictly after the cursor position.
+	After *ItemCursor
+}
+
+// ItemCursor is the position of the last row of a page: the value of the sort column and the item ID.
+type ItemCursor struct {
+	Value interface{}
+	ID    uint
+}
+
+// ItemTextQuery is a parsed full-text query. All parts must match.
+type ItemTextQuery struct {
+	Terms    []string
+	Phrases  []string
+	Prefixes []string
+
+	Limit  int
+	Offset int
+}
+
+// ItemSearchHit is an item matched by a full-text query with its relevance and highlighted snippets.
+// Matches in the snippets are enclosed in HighlightStart and HighlightStop, the text itself is not escaped.
+type ItemSearchHit struct {
+	models.Item
+	Rank                 float64
+	NameHighlight        string
+	DescriptionHighlight string
+}
+
+// HighlightStart and HighlightStop enclose matches in ItemSearchHit snippets. They are private use characters,
+// so that callers can escape the text before turning them into markup.
+const (
+	HighlightStart = "\uE000"
+	HighlightStop  = "\uE001"
+)
+
+// TextSearchConfig is the PostgreSQL text search configuration used for the items.search_vector column.
+// It must match the configuration of the generated column created by the migration.
+const TextSearchConfig = "english"
+
+func orderItemImages(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+var itemSortColumns = map[string]string{
+	"price":      "price",
+	"created_at": "created_at",
+	"name":       "name",
+}
+
 type IItemRepository interface {
-	FindAll() ([]models.Item, error)
+	Search(query ItemQuery) ([]models.Item, int64, error)
+	FullTextSearch(query ItemTextQuery) ([]ItemSearchHit, int64, error)
 	FindById(id uint) (models.Item, error)
 	Create(item models.Item, userId uint) (*models.Item, error)
 	Update(id uint, item models.Item) (*models.Item, error)
+	ReplaceTags(id uint, tags []models.Tag) error
 	Delete(id uint) error
 	Purchase(itemID uint, quantity uint) error
 	DeductItemQuantity(itemID uint, quantity uint) error
@@ -31,17 +104,139 @@ func NewItemRepository(db *gorm.DB) IItemRepository {
 	return &ItemRepository{db: db}
 }
 
-func (r *ItemRepository) FindAll() ([]models.Item, error) {
+// Search returns one page of items matching the query and the total number of matching items.
+// The total count ignores the cursor and offset.
+func (r *ItemRepository) Search(query ItemQuery) ([]models.Item, int64, error) {
+	column, ok := itemSortColumns[query.SortBy]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported sort column: %s", query.SortBy)
+	}
+
+	filtered := r.db.Model(&models.Item{})
+	if query.MinPrice != nil {
+		filtered = filtered.Where("price >= ?", *query.MinPrice)
+	}
+	if query.MaxPrice != nil {
+		filtered = filtered.Where("price <= ?", *query.MaxPrice)
+	}
+	if query.UserID != nil {
+		filtered = filtered.Where("user_id = ?", *query.UserID)
+	}
+	if query.SoldOut != nil {
+		filtered = filtered.Where("sold_out = ?", *query.SoldOut)
+	}
+	if query.MinQuantity != nil {
+		filtered = filtered.Where("quantity >= ?", *query.MinQuantity)
+	}
+	if query.CategoryID != nil {
+		filtered = filtered.Where("category_id IN ("+categorySubtreeSQL+")", *query.CategoryID)
+	}
+	for _, tag := range query.Tags {
+		filtered = filtered.Where(`EXISTS (
+			SELECT 1 FROM item_tags JOIN tags ON tags.id = item_tags.tag_id
+			WHERE item_tags.item_id = items.id AND tags.name = ?)`, tag)
+	}
+
+	var total int64
+	if err := filtered.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	direction := "ASC"
+	comparison := ">"
+	if query.Desc {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	page := filtered.Session(&gorm.Session{})
+	if query.After != nil {
+		// row comparison keeps the keyset stable when several rows share the same sort value
+		page = page.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison), query.After.Value, query.After.ID)
+	} else if query.Offset > 0 {
+		page = page.Offset(query.Offset)
+	}
+
 	var items []models.Item
-	if err := r.db.Find(&items).Error; err != nil {
-		return nil, err
+	err := page.
+		Preload("Category").
+		Preload("Tags").
+		Preload("Images", orderItemImages).
+		Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).
+		Limit(query.Limit).
+		Find(&items).Error
+	if err != nil {
+		return nil, 0, err
 	}
-	return items, nil
+	return items, total, nil
+}
+
+// FullTextSearch ranks items by relevance against the generated search_vector column.
+func (r *ItemRepository) FullTextSearch(query ItemTextQuery) ([]ItemSearchHit, int64, error) {
+	var parts []string
+	var args []interface{}
+	if len(query.Terms) > 0 {
+		parts = append(parts, "plainto_tsquery('"+TextSearchConfig+"', ?)")
+		args = append(args, strings.Join(query.Terms, " "))
+	}
+	for _, phrase := range query.Phrases {
+		parts = append(parts, "phraseto_tsquery('"+TextSearchConfig+"', ?)")
+		args = append(args, phrase)
+	}
+	for _, prefix := range query.Prefixes {
+		parts = append(parts, "to_tsquery('"+TextSearchConfig+"', ?)")
+		args = append(args, prefix+":*")
+	}
+	if len(parts) == 0 {
+		return nil, 0, errors.New("empty search query")
+	}
+
+	matched := r.db.Model(&models.Item{}).
+		Joins("CROSS JOIN (SELECT "+strings.Join(parts, " && ")+" AS query) AS search", args...).
+		Where("items.search_vector @@ search.query")
+
+	var total int64
+	if err := matched.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var hits []ItemSearchHit
+	err := matched.Session(&gorm.Session{}).
+		Select(`items.*,
+			ts_rank_cd(items.search_vector, search.query) AS rank,
+			ts_headline('` + TextSearchConfig + `', items.name, search.query, 'StartSel=` + HighlightStart + `, StopSel=` + HighlightStop + `, HighlightAll=true') AS name_highlight,
+			ts_headline('` + TextSearchConfig + `', coalesce(items.description, ''), search.query, 'StartSel=` + HighlightStart + `, StopSel=` + HighlightStop + `, MaxFragments=3, MaxWords=20, MinWords=5') AS description_highlight`).
+		Order("rank DESC, items.id DESC").
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Find(&hits).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(hits) > 0 {
+		itemIDs := make([]uint, len(hits))
+		for i, hit := range hits {
+			itemIDs[i] = hit.ID
+		}
+		var images []models.ItemImage
+		if err := orderItemImages(r.db.Where("item_id IN ?", itemIDs)).Find(&images).Error; err != nil {
+			return nil, 0, err
+		}
+		for i := range hits {
+			for _, image := range images {
+				if image.ItemID == hits[i].ID {
+					hits[i].Images = append(hits[i].Images, image)
+				}
+			}
+		}
+	}
+	return hits, total, nil
 }
 
 func (r *ItemRepository) FindById(id uint) (models.Item, error) {
 	var item models.Item
-	if err := r.db.First(&item, id).Error; err != nil {
+	if err := r.db.Preload("Category").Preload("Tags").Preload("Images", orderItemImages).First(&item, id).Error; err != nil {
 		return models.Item{}, err
 	}
 	return item, nil
@@ -56,12 +251,24 @@ func (r *ItemRepository) Create(item models.Item, userId uint) (*models.Item, er
 }
 
 func (r *ItemRepository) Update(id uint, updatedItem models.Item) (*models.Item, error) {
-	if err := r.db.Model(&models.Item{}).Where("id = ?", id).Updates(&updatedItem).Error; err != nil {
+	// tags are maintained by ReplaceTags, images by the item image repository and the category only by its ID
+	if err := r.db.Model(&models.Item{}).Where("id = ?", id).Omit("Tags", "Category", "Images").Updates(&updatedItem).Error; err != nil {
 		return nil, err
 	}
+	// Updates skips nil fields, so removing the category is done explicitly
+	if updatedItem.CategoryID == nil {
+		if err := r.db.Model(&models.Item{}).Where("id = ?", id).Update("category_id", nil).Error; err != nil {
+			return nil, err
+		}
+	}
 	return &updatedItem, nil
 }
 
+func (r *ItemRepository) ReplaceTags(id uint, tags []models.Tag) error {
+	item := models.Item{Model: gorm.Model{ID: id}}
+	return r.db.Model(&item).Association("Tags").Replace(tags)
+}
+
 func (r *ItemRepository) Delete(id uint) error {
 	if err := r.db.Delete(&models.Item{}, id).Error; err != nil {
 		return err
@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"gin-freemarket/models"
+
+	"gorm.io/gorm"
+)
+
+type IOrderRepository interface {
+	FindAll(userID uint) ([]models.Order, error)
+	FindById(userID uint, id uint) (*models.Order, error)
+	FindByIdWithoutOwner(id uint) (*models.Order, error)
+	FindAllBySeller(sellerID uint) ([]models.Order, error)
+	FindHistory(orderID uint) ([]models.OrderStatusHistory, error)
+}
+
+type OrderRepository struct {
+	db *gorm.DB
+}
+
+func NewOrderRepository(db *gorm.DB) IOrderRepository {
+	return &OrderRepository{db: db}
+}
+
+func (r *OrderRepository) FindAll(userID uint) ([]models.Order, error) {
+	var orders []models.Order
+	err := r.db.Preload("Lines.Item", withDeletedItems).Where("user_id = ?", userID).Order("id DESC").Find(&orders).Error
+	return orders, err
+}
+
+func (r *OrderRepository) FindById(userID uint, id uint) (*models.Order, error) {
+	var order models.Order
+	err := r.db.Preload("Lines.Item", withDeletedItems).Where("user_id = ? AND id = ?", userID, id).First(&order).Error
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// FindByIdWithoutOwner loads an order of any user, callers check who may see it.
+func (r *OrderRepository) FindByIdWithoutOwner(id uint) (*models.Order, error) {
+	var order models.Order
+	if err := r.db.Preload("Lines.Item", withDeletedItems).First(&order, id).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// FindAllBySeller returns the orders with at least one item sold by the user.
+func (r *OrderRepository) FindAllBySeller(sellerID uint) ([]models.Order, error) {
+	var orders []models.Order
+	err := r.db.Preload("Lines.Item", withDeletedItems).
+		Where(`EXISTS (
+			SELECT 1 FROM order_lines l JOIN items i ON i.id = l.item_id
+			WHERE l.order_id = orders.id AND i.user_id = ?)`, sellerID).
+		Order("id DESC").
+		Find(&orders).Error
+	return orders, err
+}
+
+// withDeletedItems preloads the items of order lines even after the seller deleted the listing,
+// the order still belongs to that seller.
+func withDeletedItems(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+func (r *OrderRepository) FindHistory(orderID uint) ([]models.OrderStatusHistory, error) {
+	var history []models.OrderStatusHistory
+	err := r.db.Where("order_id = ?", orderID).Order("id").Find(&history).Error
+	return history, err
+}
@@ -6,10 +6,11 @@ import (
 	"gorm.io/gorm"
 )
 
+// IPurchaseRepository reads purchases, which are the order lines of a user's orders.
 type IPurchaseRepository interface {
-	Create(purchase *models.Purchase) error
-	FindAll(userID uint) ([]models.Purchase, error)
-	FindById(userID uint, id uint) (*models.Purchase, error)
+	FindAll(userID uint) ([]models.OrderLine, error)
+	FindById(userID uint, id uint) (*models.OrderLine, error)
+	FindByIdWithoutOwner(id uint) (*models.OrderLine, error)
 }
 
 type PurchaseRepository struct {
@@ -20,21 +21,32 @@ func NewPurchaseRepository(db *gorm.DB) IPurchaseRepository {
 	return &PurchaseRepository{db: db}
 }
 
-func (r *PurchaseRepository) Create(purchase *models.Purchase) error {
-	return r.db.Create(purchase).Error
+func (r *PurchaseRepository) FindAll(userID uint) ([]models.OrderLine, error) {
+	var lines []models.OrderLine
+	err := r.db.Joins("Order").Preload("Item", withDeletedItems).
+		Where(`"Order".user_id = ?`, userID).
+		Order("order_lines.id").
+		Find(&lines).Error
+	return lines, err
 }
 
-func (r *PurchaseRepository) FindAll(userID uint) ([]models.Purchase, error) {
-	var purchases []models.Purchase
-	err := r.db.Preload("User").Preload("Item").Where("user_id = ?", userID).Find(&purchases).Error
-	return purchases, err
+func (r *PurchaseRepository) FindById(userID uint, id uint) (*models.OrderLine, error) {
+	var line models.OrderLine
+	err := r.db.Joins("Order").Preload("Item", withDeletedItems).
+		Where(`"Order".user_id = ? AND order_lines.id = ?`, userID, id).
+		First(&line).Error
+	if err != nil {
+		return nil, err
+	}
+	return &line, nil
 }
 
-func (r *PurchaseRepository) FindById(userID uint, id uint) (*models.Purchase, error) {
-	var purchase models.Purchase
-	err := r.db.Preload("User").Preload("Item").Where("user_id = ? AND id = ?", userID, id).First(&purchase).Error
+// FindByIdWithoutOwner loads a purchase of any user, callers check who may see it.
+func (r *PurchaseRepository) FindByIdWithoutOwner(id uint) (*models.OrderLine, error) {
+	var line models.OrderLine
+	err := r.db.Joins("Order").Preload("Item", withDeletedItems).Where("order_lines.id = ?", id).First(&line).Error
 	if err != nil {
 		return nil, err
 	}
-	return &purchase, nil
+	return &line, nil
 }
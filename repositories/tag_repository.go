@@ -0,0 +1,50 @@
+package repositories
+
+import (
+	"gin-freemarket/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ITagRepository interface {
+	FindAll() ([]models.Tag, error)
+	FindOrCreate(names []string) ([]models.Tag, error)
+}
+
+type TagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) ITagRepository {
+	return &TagRepository{db: db}
+}
+
+func (r *TagRepository) FindAll() ([]models.Tag, error) {
+	var tags []models.Tag
+	if err := r.db.Order("name").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// FindOrCreate returns the tags with the given names, creating the missing ones.
+func (r *TagRepository) FindOrCreate(names []string) ([]models.Tag, error) {
+	if len(names) == 0 {
+		return []models.Tag{}, nil
+	}
+
+	newTags := make([]models.Tag, len(names))
+	for i, name := range names {
+		newTags[i] = models.Tag{Name: name}
+	}
+	if err := r.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).Create(&newTags).Error; err != nil {
+		return nil, err
+	}
+
+	var tags []models.Tag
+	if err := r.db.Where("name IN ?", names).Order("name").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
@@ -0,0 +1,40 @@
+package repositories
+
+import (
+	"gin-freemarket/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type IWebhookEventRepository interface {
+	FindByEventID(eventID string) (*models.ProcessedWebhookEvent, error)
+	FindDueIDs(now time.Time, limit int) ([]uint, error)
+}
+
+type WebhookEventRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookEventRepository(db *gorm.DB) IWebhookEventRepository {
+	return &WebhookEventRepository{db: db}
+}
+
+func (r *WebhookEventRepository) FindByEventID(eventID string) (*models.ProcessedWebhookEvent, error) {
+	var event models.ProcessedWebhookEvent
+	if err := r.db.Where("event_id = ?", eventID).First(&event).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// FindDueIDs returns pending events whose next attempt is due, oldest first.
+func (r *WebhookEventRepository) FindDueIDs(now time.Time, limit int) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.ProcessedWebhookEvent{}).
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookEventPending, now).
+		Order("id").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidAPIKey       = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyExpiryPassed  = errors.New("expires_at must be in the future")
+	ErrTooManyAPIKeys      = errors.New("too many active API keys, revoke one first")
+	ErrAPIKeyUserSuspended = errors.New("the owner of the API key is suspended")
+)
+
+const (
+	// apiKeyPrefix makes keys recognizable, e.g. for secret scanners
+	apiKeyPrefix     = "fmk_"
+	maxActiveAPIKeys = 20
+	// lastUsedInterval is the resolution of the last-used timestamp
+	lastUsedInterval = time.Minute
+)
+
+type IAPIKeyService interface {
+	Create(userID uint, input dto.CreateAPIKeyInput) (*dto.CreatedAPIKeyResponse, error)
+	FindAll(userID uint) ([]dto.APIKeyResponse, error)
+	Revoke(userID uint, id uint) error
+	// Authenticate returns the user and the key for the key given in the Authorization header.
+	Authenticate(rawKey string) (*models.User, *models.APIKey, error)
+}
+
+type APIKeyService struct {
+	apiKeyRepository repositories.IAPIKeyRepository
+	db               *gorm.DB
+}
+
+func NewAPIKeyService(apiKeyRepository repositories.IAPIKeyRepository, db *gorm.DB) IAPIKeyService {
+	return &APIKeyService{apiKeyRepository: apiKeyRepository, db: db}
+}
+
+func (s *APIKeyService) Create(userID uint, input dto.CreateAPIKeyInput) (*dto.CreatedAPIKeyResponse, error) {
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, ErrAPIKeyExpiryPassed
+	}
+
+	var active int64
+	if err := s.db.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Count(&active).Error; err != nil {
+		return nil, err
+	}
+	if active >= maxActiveAPIKeys {
+		return nil, ErrTooManyAPIKeys
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	rawKey := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      input.Name,
+		Prefix:    rawKey[:len(apiKeyPrefix)+8],
+		KeyHash:   hashAPIKey(rawKey),
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := s.apiKeyRepository.Create(key); err != nil {
+		return nil, err
+	}
+	log.Println("API key created : User ID = ", userID, ", Key ID = ", key.ID, ", Scopes = ", key.Scopes)
+
+	return &dto.CreatedAPIKeyResponse{APIKeyResponse: dto.ToAPIKeyResponse(key), Key: rawKey}, nil
+}
+
+func (s *APIKeyService) FindAll(userID uint) ([]dto.APIKeyResponse, error) {
+	keys, err := s.apiKeyRepository.FindAll(userID)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]dto.APIKeyResponse, len(keys))
+	for i := range keys {
+		responses[i] = dto.ToAPIKeyResponse(&keys[i])
+	}
+	return responses, nil
+}
+
+func (s *APIKeyService) Revoke(userID uint, id uint) error {
+	if err := s.apiKeyRepository.Revoke(userID, id, time.Now()); err != nil {
+		return err
+	}
+	log.Println("API key revoked : User ID = ", userID, ", Key ID = ", id)
+	return nil
+}
+
+// Authenticate looks the key up by its hash. The permissions of the user are resolved from the database
+// on every request, so a role change or suspension applies to keys right away.
+func (s *APIKeyService) Authenticate(rawKey string) (*models.User, *models.APIKey, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	key, err := s.apiKeyRepository.FindByHash(hashAPIKey(rawKey))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(now)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if key.User.SuspendedAt != nil {
+		return nil, nil, ErrAPIKeyUserSuspended
+	}
+
+	if err := s.apiKeyRepository.TouchLastUsed(key.ID, now, lastUsedInterval); err != nil {
+		log.Println("Touch API key failed : Key ID = ", key.ID, ", Error = ", err)
+	}
+
+	user := key.User
+	user.Permissions = user.GrantedPermissions()
+	return &user, key, nil
+}
+
+// hashAPIKey is a plain SHA-256, the key is random and long enough not to need a slow hash
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/mailer"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidActionToken   = errors.New("invalid or expired token")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
+
+const (
+	verifyEmailTokenTTL   = 24 * time.Hour
+	passwordResetTokenTTL = time.Hour
+)
+
+// actionTokenPayload is the signed part of the tokens sent by email.
+// A token is base64url(JSON payload) + "." + base64url(HMAC-SHA256 of the first part).
+type actionTokenPayload struct {
+	Purpose   models.ActionTokenPurpose `json:"p"`
+	UserID    uint                      `json:"uid"`
+	Nonce     string                    `json:"n"`
+	ExpiresAt int64                     `json:"exp"`
+}
+
+// LoadActionTokenSecret reads ACTION_TOKEN_SECRET, the key signing email verification and password reset tokens.
+// Without it a random key is used, and tokens sent before a restart stop working.
+func LoadActionTokenSecret() []byte {
+	if secret := os.Getenv("ACTION_TOKEN_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Println("ACTION_TOKEN_SECRET is not set, emailed tokens will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("failed to generate action token secret: " + err.Error())
+	}
+	return secret
+}
+
+// LoadAppBaseURL reads APP_BASE_URL, the address of the web app the emailed links point to.
+func LoadAppBaseURL() string {
+	if baseURL := os.Getenv("APP_BASE_URL"); baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	return "http://localhost:8081"
+}
+
+// SendVerificationEmail mails the user a link to verify the email address.
+func (s *AuthService) SendVerificationEmail(userID uint) error {
+	user, err := s.authRepository.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := s.issueActionToken(s.db, user.ID, models.ActionTokenVerifyEmail, verifyEmailTokenTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(context.Background(), mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Open the link below to verify your email address. You can list items once it is verified.\n\n" +
+			s.appBaseURL + "/verify-email?token=" + url.QueryEscape(token) + "\n\n" +
+			"The link expires in 24 hours.\n",
+	})
+}
+
+// VerifyEmail marks the email address of the token's user as verified.
+// The new permissions take effect with the next access token, after a refresh or login.
+func (s *AuthService) VerifyEmail(token string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		userID, err := useActionToken(tx, s.actionTokenSecret, token, models.ActionTokenVerifyEmail)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ? AND email_verified_at IS NULL", userID).
+			Update("email_verified_at", time.Now()).Error
+	})
+}
+
+// RequestPasswordReset mails a reset link if an account with the email exists.
+// The lookup and the mail happen in the background and failures are only logged, so neither the answer
+// nor its timing reveals which addresses have accounts.
+func (s *AuthService) RequestPasswordReset(email string) {
+	go func() {
+		if err := s.sendPasswordReset(email); err != nil {
+			log.Println("Send password reset failed : Error = ", err)
+		}
+	}()
+}
+
+func (s *AuthService) sendPasswordReset(email string) error {
+	user, err := s.authRepository.GetUserByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("Password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issueActionToken(s.db, user.ID, models.ActionTokenPasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(context.Background(), mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Open the link below to choose a new password.\n\n" +
+			s.appBaseURL + "/password-reset?token=" + url.QueryEscape(token) + "\n\n" +
+			"The link expires in 1 hour. If you did not ask for a new password, you can ignore this email.\n",
+	})
+}
+
+// ResetPassword sets a new password and logs the user out everywhere.
+// Other reset links sent before are invalidated as well.
+func (s *AuthService) ResetPassword(token string, newPassword string) error {
+	hashedPassword, err := s.hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	var userID uint
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		userID, err = useActionToken(tx, s.actionTokenSecret, token, models.ActionTokenPasswordReset)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ActionToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, models.ActionTokenPasswordReset).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return revokeRefreshTokens(tx.Where("user_id = ?", userID), now)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Println("Password reset : User ID = ", userID)
+	return s.sessionManager.RevokeUserTokens(userID, s.accessTokenTTL)
+}
+
+// issueActionToken records a new nonce for the user and returns the signed token carrying it.
+func (s *AuthService) issueActionToken(tx *gorm.DB, userID uint, purpose models.ActionTokenPurpose, ttl time.Duration) (string, error) {
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(ttl)
+	if err := tx.Create(&models.ActionToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Nonce:     nonce,
+		ExpiresAt: expiresAt,
+	}).Error; err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(actionTokenPayload{Purpose: purpose, UserID: userID, Nonce: nonce, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signActionToken(s.actionTokenSecret, encoded), nil
+}
+
+// useActionToken checks the signature, purpose and expiry of the token and consumes it.
+// Any failure is ErrInvalidActionToken, so callers cannot tell a forged token from a used one.
+func useActionToken(tx *gorm.DB, secret []byte, token string, purpose models.ActionTokenPurpose) (uint, error) {
+	payload, err := parseActionToken(secret, token, purpose)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+
+	// used_at IS NULL makes the token single-use even when the same link is opened twice at once
+	result := tx.Model(&models.ActionToken{}).
+		Where("nonce = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", payload.Nonce, payload.UserID, purpose, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, ErrInvalidActionToken
+	}
+	return payload.UserID, nil
+}
+
+// parseActionToken checks the signature, purpose and expiry of the token without consuming it.
+func parseActionToken(secret []byte, token string, purpose models.ActionTokenPurpose) (*actionTokenPayload, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signActionToken(secret, encoded))) {
+		return nil, ErrInvalidActionToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidActionToken
+	}
+	var payload actionTokenPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, ErrInvalidActionToken
+	}
+	if payload.Purpose != purpose || time.Now().Unix() >= payload.ExpiresAt {
+		return nil, ErrInvalidActionToken
+	}
+	return &payload, nil
+}
+
+func signActionToken(secret []byte, encoded string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
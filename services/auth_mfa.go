@@ -0,0 +1,315 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/totp"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMFANotConfigured   = errors.New("two-factor authentication is not available")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrMFAEnrollNotBegun  = errors.New("two-factor enrollment has not been started")
+	ErrInvalidMFACode     = errors.New("invalid authentication code")
+	ErrInvalidMFAResponse = errors.New("invalid or expired MFA token")
+)
+
+const (
+	mfaChallengeTTL = 5 * time.Minute
+	// maxMFAChallengeFailures wrong codes use up the MFA token, the password step has to be repeated
+	maxMFAChallengeFailures = 5
+	recoveryCodeCount       = 10
+)
+
+// LoginResult is the outcome of the password step. With 2FA enabled MFAToken is set instead of Tokens,
+// and the login is finished by CompleteMFALogin.
+type LoginResult struct {
+	Tokens   *TokenPair
+	MFAToken string
+}
+
+type MFAEnrollment struct {
+	Secret     string
+	OTPAuthURI string
+}
+
+// LoadTOTPSecretBox reads TOTP_ENCRYPTION_KEY, 32 bytes in hex encrypting TOTP secrets in the database.
+// Without it 2FA enrollment is disabled.
+func LoadTOTPSecretBox() *totp.SecretBox {
+	key := os.Getenv("TOTP_ENCRYPTION_KEY")
+	if key == "" {
+		log.Println("TOTP_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+		return nil
+	}
+	box, err := totp.NewSecretBox(key)
+	if err != nil {
+		panic("invalid TOTP_ENCRYPTION_KEY: " + err.Error())
+	}
+	return box
+}
+
+// BeginMFAEnrollment creates a new TOTP secret for the user. It only becomes active once
+// ConfirmMFAEnrollment receives a code generated from it, starting again replaces it.
+func (s *AuthService) BeginMFAEnrollment(userID uint) (*MFAEnrollment, error) {
+	if s.totpSecretBox == nil {
+		return nil, ErrMFANotConfigured
+	}
+	user, err := s.authRepository.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.totpSecretBox.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// enabled rows are left alone by the WHERE of the upsert
+	result := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"secret_encrypted": sealed, "last_counter": 0, "updated_at": time.Now()}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "user_mfa.enabled_at IS NULL"}}},
+	}).Create(&models.UserMFA{UserID: userID, SecretEncrypted: sealed})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(s.mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFAEnrollment enables 2FA when the code matches the pending secret and returns the recovery codes.
+// The codes are shown only this once.
+func (s *AuthService) ConfirmMFAEnrollment(userID uint, code string) ([]string, error) {
+	if s.totpSecretBox == nil {
+		return nil, ErrMFANotConfigured
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var mfa models.UserMFA
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&mfa).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMFAEnrollNotBegun
+		}
+		if err != nil {
+			return err
+		}
+		if mfa.EnabledAt != nil {
+			return ErrMFAAlreadyEnabled
+		}
+		if err := s.acceptTOTPCode(tx, &mfa, code); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&mfa).Update("enabled_at", now).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("MFA enabled : User ID = ", userID)
+	return codes, nil
+}
+
+// DisableMFA turns 2FA off. It takes a current TOTP or recovery code, a stolen access token alone is not enough.
+// Wrong codes count as failed logins of the user, so the code cannot be guessed through this endpoint either.
+func (s *AuthService) DisableMFA(userID uint, code string, clientIP string) error {
+	user, err := s.authRepository.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkLoginGuard(user.Email, clientIP); err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.verifyMFACode(tx, userID, code); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserMFA{}).Error
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		s.recordLoginFailure(user.Email, clientIP)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("MFA disabled : User ID = ", userID)
+	return nil
+}
+
+// CompleteMFALogin exchanges the MFA token of the password step and a TOTP or recovery code for the tokens.
+// A wrong code counts as a failed login of the user and against the MFA token,
+// which is used up after maxMFAChallengeFailures wrong codes.
+func (s *AuthService) CompleteMFALogin(mfaToken string, code string, clientIP string) (*TokenPair, error) {
+	payload, err := parseActionToken(s.actionTokenSecret, mfaToken, models.ActionTokenMFAChallenge)
+	if err != nil {
+		return nil, ErrInvalidMFAResponse
+	}
+	user, err := s.authRepository.GetUserByID(payload.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMFAResponse
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkLoginGuard(user.Email, clientIP); err != nil {
+		return nil, err
+	}
+
+	var pair *TokenPair
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := useActionToken(tx, s.actionTokenSecret, mfaToken, models.ActionTokenMFAChallenge); err != nil {
+			if errors.Is(err, ErrInvalidActionToken) {
+				return ErrInvalidMFAResponse
+			}
+			return err
+		}
+		if err := s.verifyMFACode(tx, user.ID, code); err != nil {
+			return err
+		}
+		pair, _, err = s.issueTokenPair(tx, user, "")
+		return err
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		// the transaction rolled back, so the failure is recorded on its own
+		s.recordLoginFailure(user.Email, clientIP)
+		if err := s.countMFAChallengeFailure(payload.Nonce); err != nil {
+			log.Println("Count MFA failure failed : User ID = ", user.ID, ", Error = ", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.recordLoginSuccess(user.Email)
+	return pair, nil
+}
+
+// countMFAChallengeFailure counts a wrong code against the MFA token and uses it up after too many.
+func (s *AuthService) countMFAChallengeFailure(nonce string) error {
+	return s.db.Model(&models.ActionToken{}).
+		Where("nonce = ? AND purpose = ? AND used_at IS NULL", nonce, models.ActionTokenMFAChallenge).
+		Updates(map[string]interface{}{
+			"failed_attempts": gorm.Expr("failed_attempts + 1"),
+			"used_at":         gorm.Expr("CASE WHEN failed_attempts + 1 >= ? THEN ?::timestamptz END", maxMFAChallengeFailures, time.Now()),
+		}).Error
+}
+
+// mfaEnabled reports whether the login of the user needs a second step.
+func (s *AuthService) mfaEnabled(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.UserMFA{}).Where("user_id = ? AND enabled_at IS NOT NULL", userID).Count(&count).Error
+	return count > 0, err
+}
+
+// verifyMFACode accepts a TOTP code or an unused recovery code of a user with 2FA enabled.
+func (s *AuthService) verifyMFACode(tx *gorm.DB, userID uint, code string) error {
+	var mfa models.UserMFA
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? AND enabled_at IS NOT NULL", userID).First(&mfa).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return s.acceptTOTPCode(tx, &mfa, code)
+	}
+
+	result := tx.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	log.Println("MFA recovery code used : User ID = ", userID)
+	return nil
+}
+
+// acceptTOTPCode checks the code against the locked row and records its time step,
+// so that the same code cannot be replayed within its validity window.
+func (s *AuthService) acceptTOTPCode(tx *gorm.DB, mfa *models.UserMFA, code string) error {
+	if s.totpSecretBox == nil {
+		return ErrMFANotConfigured
+	}
+	secret, err := s.totpSecretBox.Open(mfa.SecretEncrypted)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now())
+	if !ok || counter <= mfa.LastCounter {
+		return ErrInvalidMFACode
+	}
+	mfa.LastCounter = counter
+	return tx.Model(mfa).Update("last_counter", counter).Error
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the user and creates new ones.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.MFARecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		// 12 base32 characters as xxxx-xxxx-xxxx, easy to type from paper
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:12]
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12]
+		rows[i] = models.MFARecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(codes[i])}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// recovery codes are random like refresh tokens, so SHA-256 is enough.
+// Case and separators are ignored when the user types the code back.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/oidc"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOIDCStateMismatch    = errors.New("login state is missing or does not match, start the login again")
+	ErrOIDCEmailNotVerified = errors.New("the identity provider did not return a verified email address")
+	ErrOIDCProvider         = errors.New("identity provider request failed")
+)
+
+const (
+	// oidcStateTTL is the time the user has to sign in at the provider
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateDomain separates the state signatures from action tokens signed with the same secret
+	oidcStateDomain = "oidc-state:"
+)
+
+// OIDCAuthorization is the start of a login at an identity provider. The browser is sent to AuthURL
+// and StateCookie is kept in a cookie until the callback.
+type OIDCAuthorization struct {
+	AuthURL     string
+	StateCookie string
+}
+
+// oidcStatePayload is the signed cookie tying the callback to the browser that started the login.
+// The cookie is signed, not encrypted: the browser can read the PKCE verifier but cannot change it.
+// The verifier is only sent to the provider with the code exchange, the authorization request carries its hash.
+type oidcStatePayload struct {
+	Provider  string `json:"p"`
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// StartOIDCLogin creates the state, nonce and PKCE verifier of a login with the provider.
+func (s *AuthService) StartOIDCLogin(providerName string) (*OIDCAuthorization, error) {
+	provider, ok := s.oidcProviders[providerName]
+	if !ok {
+		return nil, oidc.ErrUnknownProvider
+	}
+
+	payload := oidcStatePayload{Provider: providerName, ExpiresAt: time.Now().Add(oidcStateTTL).Unix()}
+	for _, value := range []*string{&payload.State, &payload.Nonce, &payload.Verifier} {
+		random, err := oidc.RandomString()
+		if err != nil {
+			return nil, err
+		}
+		*value = random
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), payload.State, payload.Nonce, payload.Verifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(raw)
+	return &OIDCAuthorization{
+		AuthURL:     authURL,
+		StateCookie: encoded + "." + signActionToken(s.actionTokenSecret, oidcStateDomain+encoded),
+	}, nil
+}
+
+// CompleteOIDCLogin redeems the authorization code of the callback and logs in the user of the identity.
+// A new identity is linked to the user with the same email address, or to a new user, only when the provider
+// verified the address. Users with 2FA still have to pass it.
+func (s *AuthService) CompleteOIDCLogin(providerName string, code string, state string, stateCookie string) (*LoginResult, error) {
+	provider, ok := s.oidcProviders[providerName]
+	if !ok {
+		return nil, oidc.ErrUnknownProvider
+	}
+	payload, err := s.parseOIDCState(stateCookie)
+	if err != nil || payload.Provider != providerName || payload.State == "" || !hmac.Equal([]byte(payload.State), []byte(state)) {
+		return nil, ErrOIDCStateMismatch
+	}
+
+	claims, err := provider.Exchange(context.Background(), code, payload.Verifier, payload.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+
+	user, err := s.linkOIDCIdentity(providerName, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	mfaRequired, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaRequired {
+		mfaToken, err := s.issueActionToken(s.db, user.ID, models.ActionTokenMFAChallenge, mfaChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: mfaToken}, nil
+	}
+
+	pair, _, err := s.issueTokenPair(s.db, user, "")
+	if err != nil {
+		log.Println("Create token failed : ", err)
+		return nil, err
+	}
+	return &LoginResult{Tokens: pair}, nil
+}
+
+// linkOIDCIdentity returns the user of the identity, linking it on the first login.
+func (s *AuthService) linkOIDCIdentity(providerName string, claims *oidc.Claims) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+		if err == nil {
+			return tx.First(&user, identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// an unverified address could be anybody's, linking it would hand over the account
+		if claims.Email == "" || !claims.EmailVerified {
+			return ErrOIDCEmailNotVerified
+		}
+
+		now := time.Now()
+		err = tx.Where("LOWER(email) = LOWER(?)", claims.Email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// the account has no usable password until the user resets it
+			randomPassword, err := randomToken()
+			if err != nil {
+				return err
+			}
+			hashedPassword, err := s.passwordHasher.Hash(randomPassword)
+			if err != nil {
+				return err
+			}
+			user = models.User{Email: claims.Email, Password: hashedPassword, EmailVerifiedAt: &now}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			log.Println("User created from identity provider : User ID = ", user.ID, ", Provider = ", providerName)
+		case err != nil:
+			return err
+		case user.EmailVerifiedAt == nil:
+			// the provider proved the ownership of the address
+			if err := tx.Model(&user).Update("email_verified_at", now).Error; err != nil {
+				return err
+			}
+		}
+
+		log.Println("Identity linked : User ID = ", user.ID, ", Provider = ", providerName)
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: providerName,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *AuthService) parseOIDCState(stateCookie string) (*oidcStatePayload, error) {
+	encoded, signature, ok := strings.Cut(stateCookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signActionToken(s.actionTokenSecret, oidcStateDomain+encoded))) {
+		return nil, ErrOIDCStateMismatch
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrOIDCStateMismatch
+	}
+	var payload oidcStatePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, ErrOIDCStateMismatch
+	}
+	if time.Now().Unix() > payload.ExpiresAt {
+		return nil, ErrOIDCStateMismatch
+	}
+	return &payload, nil
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"gin-freemarket/models"
+	"gin-freemarket/utils/passwords"
+	"log"
+)
+
+// LoadPasswordPolicy reads the password rules, see passwords.LoadPolicyFromEnv.
+func LoadPasswordPolicy() *passwords.Policy {
+	policy, err := passwords.LoadPolicyFromEnv()
+	if err != nil {
+		panic("failed to load password policy: " + err.Error())
+	}
+	return policy
+}
+
+// LoadPasswordHasher hashes new passwords with argon2id and still accepts the bcrypt hashes salted with SALT.
+func LoadPasswordHasher() *passwords.Hasher {
+	return passwords.NewHasher(passwords.LoadArgon2ParamsFromEnv(), SALT)
+}
+
+// hashPassword checks the new password against the policy and hashes it.
+func (s *AuthService) hashPassword(password string) (string, error) {
+	if err := s.passwordPolicy.Check(password); err != nil {
+		return "", err
+	}
+	return s.passwordHasher.Hash(password)
+}
+
+// checkPassword verifies the password of the user, nil standing for an unknown email.
+// Unknown emails are checked against a dummy hash, so that they take as long to reject as a wrong password.
+// A legacy or outdated hash of a correct password is replaced, a failure to do so does not fail the login.
+func (s *AuthService) checkPassword(user *models.User, password string) bool {
+	if user == nil {
+		s.passwordHasher.Verify(password, s.dummyPasswordHash)
+		return false
+	}
+
+	ok, needsRehash, err := s.passwordHasher.Verify(password, user.Password)
+	if err != nil {
+		log.Println("Verify password failed : User ID = ", user.ID, ", Error = ", err)
+		return false
+	}
+	if ok && needsRehash {
+		// the policy is not applied, the user did not choose a new password
+		hashedPassword, err := s.passwordHasher.Hash(password)
+		if err == nil {
+			err = s.db.Model(&models.User{}).Where("id = ? AND password = ?", user.ID, user.Password).Update("password", hashedPassword).Error
+		}
+		if err != nil {
+			log.Println("Rehash password failed : User ID = ", user.ID, ", Error = ", err)
+		}
+	}
+	return ok
+}
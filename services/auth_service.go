@@ -5,82 +5,248 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"gin-freemarket/dto"
 	"gin-freemarket/models"
 	"gin-freemarket/repositories"
+	"gin-freemarket/utils/env"
+	"gin-freemarket/utils/jwtkeys"
+	"gin-freemarket/utils/loginguard"
+	"gin-freemarket/utils/mailer"
+	"gin-freemarket/utils/oidc"
+	"gin-freemarket/utils/passwords"
+	"gin-freemarket/utils/sessions"
+	"gin-freemarket/utils/totp"
 	"log"
-	"os"
-
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-// salt is a random string that is used to hash the password
+var (
+	ErrTokenRevoked = errors.New("token has been revoked")
+	// ErrInvalidCredentials is returned for an unknown email and a wrong password alike
+	ErrInvalidCredentials   = errors.New("invalid email or password")
+	ErrTooManyLoginAttempts = errors.New("too many failed login attempts, try again later")
+)
+
+// LoginThrottledError is returned while failed attempts block logins to the account or from the IP.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return ErrTooManyLoginAttempts.Error()
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrTooManyLoginAttempts
+}
+
+// SALT was appended to every password before bcrypt, it is only needed to check legacy hashes
 const SALT = "...salt..."
 
 type IAuthService interface {
 	Register(email string, password string) error
-	Login(email string, password string) (*string, error)
+	Login(email string, password string, clientIP string) (*LoginResult, error)
+	CompleteMFALogin(mfaToken string, code string, clientIP string) (*TokenPair, error)
+	BeginMFAEnrollment(userID uint) (*MFAEnrollment, error)
+	ConfirmMFAEnrollment(userID uint, code string) ([]string, error)
+	DisableMFA(userID uint, code string, clientIP string) error
+	StartOIDCLogin(provider string) (*OIDCAuthorization, error)
+	CompleteOIDCLogin(provider string, code string, state string, stateCookie string) (*LoginResult, error)
+	Refresh(refreshToken string) (*TokenPair, error)
+	Logout(userID uint, token string, refreshToken string) error
+	LogoutAll(userID uint, token string) error
+	ListSessions(userID uint, currentToken string) ([]dto.SessionResponse, error)
 	GetUserFromToken(token string) (*models.User, error)
+	JWKS() jwtkeys.JWKS
+	SendVerificationEmail(userID uint) error
+	VerifyEmail(token string) error
+	RequestPasswordReset(email string)
+	ResetPassword(token string, newPassword string) error
 }
 
 type AuthService struct {
-	authRepository repositories.IAuthRepository
-	db             *gorm.DB
+	authRepository  repositories.IAuthRepository
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	sessionManager  sessions.ISessionManager
+	keySet          *jwtkeys.KeySet
+	// mailer sends the verification and password reset emails, with links signed by actionTokenSecret
+	mailer            mailer.Mailer
+	actionTokenSecret []byte
+	appBaseURL        string
+	// totpSecretBox is nil when 2FA is not configured
+	totpSecretBox *totp.SecretBox
+	mfaIssuer     string
+	// oidcProviders are the identity providers users can log in with, by name
+	oidcProviders map[string]*oidc.Provider
+	// loginGuard counts failed logins and blocks guessing
+	loginGuard *loginguard.Guard
+	// passwordHasher hashes with argon2id and upgrades legacy hashes at login
+	passwordHasher    *passwords.Hasher
+	passwordPolicy    *passwords.Policy
+	dummyPasswordHash string
+	db                *gorm.DB
 }
 
-func NewAuthService(authRepository repositories.IAuthRepository, db *gorm.DB) IAuthService {
-	return &AuthService{authRepository: authRepository, db: db}
+func NewAuthService(
+	authRepository repositories.IAuthRepository,
+	sessionManager sessions.ISessionManager,
+	keySet *jwtkeys.KeySet,
+	mailer mailer.Mailer,
+	oidcProviders map[string]*oidc.Provider,
+	loginGuard *loginguard.Guard,
+	db *gorm.DB,
+) IAuthService {
+	passwordHasher := LoadPasswordHasher()
+	dummyPasswordHash, err := passwordHasher.Hash("dummy password")
+	if err != nil {
+		panic("failed to hash dummy password: " + err.Error())
+	}
+	return &AuthService{
+		authRepository:    authRepository,
+		sessionManager:    sessionManager,
+		keySet:            keySet,
+		mailer:            mailer,
+		actionTokenSecret: LoadActionTokenSecret(),
+		appBaseURL:        LoadAppBaseURL(),
+		totpSecretBox:     LoadTOTPSecretBox(),
+		mfaIssuer:         env.String("MFA_ISSUER", "gin-freemarket"),
+		oidcProviders:     oidcProviders,
+		loginGuard:        loginGuard,
+		passwordHasher:    passwordHasher,
+		passwordPolicy:    LoadPasswordPolicy(),
+		dummyPasswordHash: dummyPasswordHash,
+		accessTokenTTL:    LoadAccessTokenTTL(),
+		refreshTokenTTL:   LoadRefreshTokenTTL(),
+		db:                db,
+	}
 }
 
+// Register creates the user and sends the email verification link.
+// A failed email does not fail the registration, the user can ask for the link again.
 func (s *AuthService) Register(email string, password string) error {
-	saltedPassword := password + SALT
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(saltedPassword), bcrypt.DefaultCost)
+	hashedPassword, err := s.hashPassword(password)
 	if err != nil {
 		return err
 	}
-	return s.authRepository.CreateUser(models.User{Email: email, Password: string(hashedPassword)})
-}
+	if err := s.authRepository.CreateUser(models.User{Email: email, Password: hashedPassword}); err != nil {
+		return err
+	}
 
-func (s *AuthService) Login(email string, password string) (*string, error) {
 	user, err := s.authRepository.GetUserByEmail(email)
 	if err != nil {
+		return err
+	}
+	if err := s.SendVerificationEmail(user.ID); err != nil {
+		log.Println("Send verification email failed : User ID = ", user.ID, ", Error = ", err)
+	}
+	return nil
+}
+
+// Login checks the password and starts a new refresh token family.
+// For users with 2FA the result carries an MFA token for CompleteMFALogin instead of the tokens.
+// Every credential failure is ErrInvalidCredentials, and repeated failures are throttled by loginGuard.
+func (s *AuthService) Login(email string, password string, clientIP string) (*LoginResult, error) {
+	if err := s.checkLoginGuard(email, clientIP); err != nil {
 		return nil, err
 	}
-	saltedPassword := password + SALT
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(saltedPassword))
+	user, err := s.authRepository.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if !s.checkPassword(user, password) {
+		s.recordLoginFailure(email, clientIP)
+		return nil, ErrInvalidCredentials
+	}
+
+	mfaRequired, err := s.mfaEnabled(user.ID)
 	if err != nil {
 		return nil, err
 	}
+	if mfaRequired {
+		mfaToken, err := s.issueActionToken(s.db, user.ID, models.ActionTokenMFAChallenge, mfaChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: mfaToken}, nil
+	}
 
-	token, err := s.CreateToken(user.ID, user.Email)
+	pair, _, err := s.issueTokenPair(s.db, user, "")
 	if err != nil {
 		log.Println("Create token failed : ", err)
 		return nil, err
 	}
+	s.recordLoginSuccess(email)
+
+	return &LoginResult{Tokens: pair}, nil
+}
+
+// checkLoginGuard returns a LoginThrottledError while failed attempts block the account or the IP.
+// The guard fails open, Redis being down must not lock everybody out.
+func (s *AuthService) checkLoginGuard(email string, clientIP string) error {
+	retryAfter, err := s.loginGuard.Check(context.Background(), email, clientIP)
+	if err != nil {
+		log.Println("Login guard check failed : Error = ", err)
+		return nil
+	}
+	if retryAfter > 0 {
+		log.Println("Login throttled : IP = ", clientIP, ", Retry after = ", retryAfter)
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a wrong password or MFA code for the account and the IP.
+func (s *AuthService) recordLoginFailure(email string, clientIP string) {
+	if err := s.loginGuard.RecordFailure(context.Background(), email, clientIP); err != nil {
+		log.Println("Login guard record failed : Error = ", err)
+	}
+}
 
-	return token, nil
+// recordLoginSuccess clears the failures of the account once the login is complete, the MFA step included.
+// A correct password alone does not, or it would reset the count of guessed MFA codes.
+func (s *AuthService) recordLoginSuccess(email string) {
+	if err := s.loginGuard.RecordSuccess(context.Background(), email); err != nil {
+		log.Println("Login guard reset failed : Error = ", err)
+	}
 }
 
-// CreateToken creates a JWT token for the user
+// CreateToken creates a short-lived JWT access token for the user, renewed with a refresh token
 // check JWT in https://jwt.io/
-func (s *AuthService) CreateToken(userId uint, email string) (*string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userId,
-		"email":   email,
-		"exp":     time.Now().Add(time.Hour).Unix(),
-	})
+func (s *AuthService) CreateToken(user *models.User) (*string, error) {
+	// jti identifies the token on the logout denylist
+	jti, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	// permissions are resolved at issue time, a role change or suspension revokes the user's tokens
+	claims := jwt.MapClaims{
+		"user_id":     user.ID,
+		"email":       user.Email,
+		"role":        user.Role,
+		"permissions": user.GrantedPermissions(),
+		"jti":         jti,
+		"iat":         now.Unix(),
+		"exp":         now.Add(s.accessTokenTTL).Unix(),
+	}
 
 	// NOTE ------------------------------------------------------------
-	// Signature with secret key.
-	// To create secret key, use follow command
-	// $ openssl rand -hex 64
+	// Signature with the active key of JWT_KEYS_DIR, see jwtkeys.LoadKeySetFromEnv.
+	// To create a key, use one of follow commands
+	// $ openssl genpkey -algorithm ed25519 -out 2025-01.pem
+	// $ openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:2048 -out 2025-01.pem
 	// ----------------------------------------------------------------
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	tokenString, err := s.keySet.Sign(claims)
 	if err != nil {
 		log.Println("Create token failed : ", err)
 		return nil, err
@@ -91,14 +257,7 @@ func (s *AuthService) CreateToken(userId uint, email string) (*string, error) {
 func (s *AuthService) GetUserFromToken(token string) (*models.User, error) {
 
 	// decode token
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			log.Println("unexpected method: ", token.Header["alg"])
-			return nil, fmt.Errorf("unexpected method: %s", token.Header["alg"])
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
+	parsedToken, err := s.parseToken(token)
 	if err != nil {
 		log.Println("Get user from token failed : ", err)
 		return nil, err
@@ -123,11 +282,113 @@ func (s *AuthService) GetUserFromToken(token string) (*models.User, error) {
 			return nil, fmt.Errorf("email is not a string")
 		}
 
+		// logged out tokens are on the denylist until they expire
+		jti, _ := claims["jti"].(string)
+		issuedAt, _ := claims["iat"].(float64)
+		revoked, err := s.sessionManager.IsTokenRevoked(jti, uint(userID), time.Unix(int64(issuedAt), 0))
+		if err != nil {
+			log.Println("Check token revocation failed : ", err)
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+
+		role, _ := claims["role"].(string)
+		var permissions []models.Permission
+		if values, ok := claims["permissions"].([]interface{}); ok {
+			for _, value := range values {
+				if permission, ok := value.(string); ok {
+					permissions = append(permissions, models.Permission(permission))
+				}
+			}
+		}
+
 		user = &models.User{
-			Model: gorm.Model{ID: uint(userID)},
-			Email: email,
+			Model:       gorm.Model{ID: uint(userID)},
+			Email:       email,
+			Role:        models.Role(role),
+			Permissions: permissions,
 		}
 	}
 
 	return user, nil
 }
+
+// Logout revokes the access token and ends its session. When the refresh token of the same login
+// is given, its family is revoked too.
+func (s *AuthService) Logout(userID uint, token string, refreshToken string) error {
+	parsedToken, err := s.parseToken(token)
+	if err != nil {
+		return err
+	}
+	claims := parsedToken.Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return fmt.Errorf("token has no expiration: %w", err)
+	}
+
+	if refreshToken != "" {
+		var current models.RefreshToken
+		err := s.db.Where("token_hash = ? AND user_id = ?", hashRefreshToken(refreshToken), userID).First(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			if err := revokeRefreshTokens(s.db.Where("family_id = ?", current.FamilyID), time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.sessionManager.RevokeToken(jti, time.Until(expiresAt.Time)); err != nil {
+		return err
+	}
+	return s.sessionManager.DeleteSession(token)
+}
+
+// LogoutAll revokes every refresh token and every access token of the user issued until now,
+// and ends all of the user's sessions.
+func (s *AuthService) LogoutAll(userID uint, token string) error {
+	if err := revokeRefreshTokens(s.db.Where("user_id = ?", userID), time.Now()); err != nil {
+		return err
+	}
+	if err := s.sessionManager.RevokeUserTokens(userID, s.accessTokenTTL); err != nil {
+		return err
+	}
+	return s.sessionManager.DeleteUserSessions(userID)
+}
+
+// ListSessions returns the live sessions of the user. Sessions are taken by the purchase, cart and order APIs,
+// a login alone does not hold one.
+func (s *AuthService) ListSessions(userID uint, currentToken string) ([]dto.SessionResponse, error) {
+	list, err := s.sessionManager.ListUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]dto.SessionResponse, len(list))
+	for i, session := range list {
+		id := sha256.Sum256([]byte(session.Token))
+		responses[i] = dto.SessionResponse{
+			ID:         hex.EncodeToString(id[:8]),
+			Current:    session.Token == currentToken,
+			Device:     session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+		}
+	}
+	return responses, nil
+}
+
+// JWKS returns the public keys other services use to verify access tokens.
+func (s *AuthService) JWKS() jwtkeys.JWKS {
+	return s.keySet.JWKS()
+}
+
+// parseToken verifies the signature and the expiration of an access token.
+func (s *AuthService) parseToken(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, s.keySet.Keyfunc, jwt.WithValidMethods(s.keySet.ValidMethods()))
+}
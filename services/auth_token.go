@@ -0,0 +1,160 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/env"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// TokenPair is what a successful login or refresh returns.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// ExpiresIn is the lifetime of the access token
+	ExpiresIn time.Duration
+}
+
+// LoadAccessTokenTTL reads ACCESS_TOKEN_TTL_MINUTES, the lifetime of access tokens. Defaults to 15 minutes.
+func LoadAccessTokenTTL() time.Duration {
+	return time.Duration(env.Int("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute
+}
+
+// LoadRefreshTokenTTL reads REFRESH_TOKEN_TTL_HOURS, the lifetime of each refresh token. Defaults to 30 days.
+func LoadRefreshTokenTTL() time.Duration {
+	return time.Duration(env.Int("REFRESH_TOKEN_TTL_HOURS", 30*24)) * time.Hour
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token.
+// The presented token can never be used again. If it already was, it has leaked:
+// all tokens descending from the same login are revoked and the user has to log in again.
+func (s *AuthService) Refresh(refreshToken string) (*TokenPair, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	// the row lock makes concurrent refreshes with the same token see each other's rotation
+	var current models.RefreshToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hashRefreshToken(refreshToken)).
+		First(&current).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case current.RevokedAt != nil:
+		tx.Rollback()
+		return nil, ErrInvalidRefreshToken
+	case current.RotatedAt != nil:
+		log.Println("Refresh token reused, revoking family : User ID = ", current.UserID, ", Family ID = ", current.FamilyID)
+		if err := revokeRefreshTokens(tx.Where("family_id = ?", current.FamilyID), now); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, ErrInvalidRefreshToken
+	case now.After(current.ExpiresAt):
+		tx.Rollback()
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var user models.User
+	if err := tx.First(&user, current.UserID).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	pair, next, err := s.issueTokenPair(tx, &user, current.FamilyID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	current.RotatedAt = &now
+	current.ReplacedByID = &next.ID
+	if err := tx.Model(&current).Select("RotatedAt", "ReplacedByID").Updates(&current).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return pair, nil
+}
+
+// issueTokenPair creates an access token and stores a new refresh token in the family.
+// An empty familyID starts a new family, as a login does.
+func (s *AuthService) issueTokenPair(tx *gorm.DB, user *models.User, familyID string) (*TokenPair, *models.RefreshToken, error) {
+	accessToken, err := s.CreateToken(user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	if familyID == "" {
+		if familyID, err = randomToken(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	row := &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashRefreshToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.refreshTokenTTL),
+	}
+	if err := tx.Create(row).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  *accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    s.accessTokenTTL,
+	}, row, nil
+}
+
+// revokeRefreshTokens revokes the not yet revoked refresh tokens matched by query.
+func revokeRefreshTokens(query *gorm.DB, now time.Time) error {
+	return query.Model(&models.RefreshToken{}).Where("revoked_at IS NULL").Update("revoked_at", now).Error
+}
+
+// refresh tokens are random, so a fast unsalted hash is enough to keep them unusable from a DB dump
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/jwtkeys"
+	"testing"
+	"time"
+)
+
+// newTestTokenService opens the test database with no stored refresh tokens and creates a user to log in.
+func newTestTokenService(t *testing.T) (*AuthService, *models.User) {
+	db := openTestDB(t, []interface{}{&models.User{}, &models.RefreshToken{}}, &models.RefreshToken{})
+
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_SECRET", "test-secret")
+	keySet, err := jwtkeys.LoadKeySetFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &models.User{Email: fmt.Sprintf("refresh-%d@example.com", time.Now().UnixNano()), Password: "unused"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &AuthService{
+		accessTokenTTL:  time.Minute,
+		refreshTokenTTL: time.Hour,
+		keySet:          keySet,
+		db:              db,
+	}, user
+}
+
+// login starts a new token family, as a successful login does.
+func login(t *testing.T, s *AuthService, user *models.User) *TokenPair {
+	t.Helper()
+	pair, _, err := s.issueTokenPair(s.db, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func mustRefresh(t *testing.T, s *AuthService, refreshToken string) *TokenPair {
+	t.Helper()
+	pair, err := s.Refresh(refreshToken)
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	return pair
+}
+
+func mustRejectRefresh(t *testing.T, s *AuthService, refreshToken string) {
+	t.Helper()
+	if _, err := s.Refresh(refreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("got %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	s, user := newTestTokenService(t)
+	first := login(t, s, user)
+
+	second := mustRefresh(t, s, first.RefreshToken)
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+		t.Fatal("refresh did not issue a new token pair")
+	}
+	mustRefresh(t, s, second.RefreshToken)
+	mustRejectRefresh(t, s, "unknown")
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	s, user := newTestTokenService(t)
+	stolen := login(t, s, user)
+	// another device of the same user is a family of its own
+	otherDevice := login(t, s, user)
+
+	second := mustRefresh(t, s, stolen.RefreshToken)
+	latest := mustRefresh(t, s, second.RefreshToken)
+
+	// replaying a rotated token means it leaked, the current token of the family dies with it
+	mustRejectRefresh(t, s, stolen.RefreshToken)
+	mustRejectRefresh(t, s, latest.RefreshToken)
+	mustRejectRefresh(t, s, second.RefreshToken)
+
+	var active []models.RefreshToken
+	if err := s.db.Where("user_id = ? AND revoked_at IS NULL", user.ID).Find(&active).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].TokenHash != hashRefreshToken(otherDevice.RefreshToken) {
+		t.Fatalf("%d tokens left unrevoked, want only the other device's", len(active))
+	}
+	mustRefresh(t, s, otherDevice.RefreshToken)
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCartEmpty      = errors.New("cart is empty")
+	ErrItemOutOfStock = errors.New("item out of stock")
+)
+
+type ICartService interface {
+	FindAll(userID uint) (*dto.CartResponse, error)
+	Add(userID uint, input dto.AddCartItemInput) (*dto.CartResponse, error)
+	Update(userID uint, itemID uint, input dto.UpdateCartItemInput) (*dto.CartResponse, error)
+	Remove(userID uint, itemID uint) (*dto.CartResponse, error)
+	Clear(userID uint) error
+	Checkout(userID uint) ([]*dto.OrderResponse, error)
+}
+
+type CartService struct {
+	cartRepository  repositories.ICartRepository
+	itemRepository  repositories.IItemRepository
+	orderRepository repositories.IOrderRepository
+	paymentOutbox   IPaymentOutbox
+	pricing         OrderPricing
+	db              *gorm.DB
+}
+
+func NewCartService(
+	cartRepository repositories.ICartRepository,
+	itemRepository repositories.IItemRepository,
+	orderRepository repositories.IOrderRepository,
+	paymentOutbox IPaymentOutbox,
+	db *gorm.DB,
+) ICartService {
+	return &CartService{
+		cartRepository:  cartRepository,
+		itemRepository:  itemRepository,
+		orderRepository: orderRepository,
+		paymentOutbox:   paymentOutbox,
+		pricing:         LoadOrderPricing(),
+		db:              db,
+	}
+}
+
+func (s *CartService) FindAll(userID uint) (*dto.CartResponse, error) {
+	cartItems, err := s.cartRepository.FindAll(userID)
+	if err != nil {
+		return nil, err
+	}
+	return dto.ToCartResponse(cartItems), nil
+}
+
+// Add puts the item in the cart. Stock is only checked loosely here, checkout does the real check.
+func (s *CartService) Add(userID uint, input dto.AddCartItemInput) (*dto.CartResponse, error) {
+	item, err := s.itemRepository.FindById(input.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	if item.SoldOut || item.Quantity < input.Quantity {
+		return nil, fmt.Errorf("%w: item %d", ErrItemOutOfStock, item.ID)
+	}
+
+	if err := s.cartRepository.Add(userID, input.ItemID, input.Quantity); err != nil {
+		return nil, err
+	}
+	return s.FindAll(userID)
+}
+
+func (s *CartService) Update(userID uint, itemID uint, input dto.UpdateCartItemInput) (*dto.CartResponse, error) {
+	if err := s.cartRepository.SetQuantity(userID, itemID, input.Quantity); err != nil {
+		return nil, err
+	}
+	return s.FindAll(userID)
+}
+
+func (s *CartService) Remove(userID uint, itemID uint) (*dto.CartResponse, error) {
+	if err := s.cartRepository.Remove(userID, itemID); err != nil {
+		return nil, err
+	}
+	return s.FindAll(userID)
+}
+
+func (s *CartService) Clear(userID uint) error {
+	return s.cartRepository.Clear(userID)
+}
+
+// Checkout buys everything in the cart in one transaction and authorizes the payments after the commit.
+// The cart is split into one order per seller, so that every seller can ship and cancel their order on their own.
+// If any line cannot be fulfilled nothing is bought and the cart is left untouched. If a payment is declined
+// the orders are cancelled and the cart is filled again.
+func (s *CartService) Checkout(userID uint) ([]*dto.OrderResponse, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	// cart rows are locked too, so that two checkouts of the same cart cannot both succeed
+	var cartItems []models.CartItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).Order("item_id").Find(&cartItems).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if len(cartItems) == 0 {
+		tx.Rollback()
+		return nil, ErrCartEmpty
+	}
+
+	// all items are locked in item ID order before the orders are placed one seller at a time,
+	// placeOrder locking them again keeps the locks already held
+	itemIDs := make([]uint, len(cartItems))
+	for i, cartItem := range cartItems {
+		itemIDs[i] = cartItem.ItemID
+	}
+	var items []models.Item
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", itemIDs).Order("id").Find(&items).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	sellers := make(map[uint]uint, len(items))
+	for _, item := range items {
+		sellers[item.ID] = item.UserID
+	}
+
+	var sellerIDs []uint
+	requestsBySeller := make(map[uint][]orderLineRequest)
+	for _, cartItem := range cartItems {
+		sellerID := sellers[cartItem.ItemID]
+		if _, ok := requestsBySeller[sellerID]; !ok {
+			sellerIDs = append(sellerIDs, sellerID)
+		}
+		requestsBySeller[sellerID] = append(requestsBySeller[sellerID], orderLineRequest{ItemID: cartItem.ItemID, Quantity: cartItem.Quantity})
+	}
+
+	var orders []*models.Order
+	for _, sellerID := range sellerIDs {
+		order, err := placeOrder(tx, s.pricing, userID, requestsBySeller[sellerID])
+		if err != nil {
+			tx.Rollback()
+			log.Println("Checkout failed : User ID = ", userID, ", Error = ", err)
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.CartItem{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// the provider is called without any lock held
+	if err := authorizeOrders(s.db, s.paymentOutbox, orders); err != nil {
+		log.Println("Checkout failed : User ID = ", userID, ", Error = ", err)
+		for _, cartItem := range cartItems {
+			if err := s.cartRepository.Add(userID, cartItem.ItemID, cartItem.Quantity); err != nil {
+				log.Println("Restore cart failed : User ID = ", userID, ", Item ID = ", cartItem.ItemID, ", Error = ", err)
+			}
+		}
+		return nil, err
+	}
+
+	responses := make([]*dto.OrderResponse, len(orders))
+	for i, order := range orders {
+		log.Println("Checkout success : User ID = ", userID, ", Order ID = ", order.ID)
+		created, err := s.orderRepository.FindById(userID, order.ID)
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = dto.ToOrderResponse(created)
+	}
+	return responses, nil
+}
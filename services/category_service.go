@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"log"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidCategoryParent = errors.New("category cannot be moved under itself or its descendants")
+	ErrCategoryHasChildren   = errors.New("category has child categories")
+)
+
+type ICategoryService interface {
+	FindAll() ([]dto.CategoryResponse, error)
+	FindById(id uint) (*models.Category, error)
+	Create(input dto.CreateCategoryInput) (*models.Category, error)
+	Update(id uint, input dto.UpdateCategoryInput) (*models.Category, error)
+	Delete(id uint) error
+	FindAllTags() ([]models.Tag, error)
+}
+
+type CategoryService struct {
+	categoryRepository repositories.ICategoryRepository
+	tagRepository      repositories.ITagRepository
+	db                 *gorm.DB
+}
+
+func NewCategoryService(categoryRepository repositories.ICategoryRepository, tagRepository repositories.ITagRepository, db *gorm.DB) ICategoryService {
+	return &CategoryService{categoryRepository: categoryRepository, tagRepository: tagRepository, db: db}
+}
+
+func (s *CategoryService) FindAll() ([]dto.CategoryResponse, error) {
+	categories, err := s.categoryRepository.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	return dto.ToCategoryTree(categories), nil
+}
+
+func (s *CategoryService) FindById(id uint) (*models.Category, error) {
+	return s.categoryRepository.FindById(id)
+}
+
+func (s *CategoryService) Create(input dto.CreateCategoryInput) (*models.Category, error) {
+	if input.ParentID != nil {
+		if _, err := s.categoryRepository.FindById(*input.ParentID); err != nil {
+			log.Println("Create category failed : Parent ID = ", *input.ParentID, ", Error = ", err)
+			return nil, err
+		}
+	}
+
+	category := &models.Category{
+		Name:     input.Name,
+		Slug:     input.Slug,
+		ParentID: input.ParentID,
+	}
+	if err := s.categoryRepository.Create(category); err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+func (s *CategoryService) Update(id uint, input dto.UpdateCategoryInput) (*models.Category, error) {
+	category, err := s.categoryRepository.FindById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Name != nil {
+		category.Name = *input.Name
+	}
+	if input.Slug != nil {
+		category.Slug = *input.Slug
+	}
+	if input.ParentID != nil {
+		if *input.ParentID == 0 {
+			category.ParentID = nil
+		} else {
+			// the new parent must exist and must not be inside the subtree being moved
+			if _, err := s.categoryRepository.FindById(*input.ParentID); err != nil {
+				return nil, err
+			}
+			subtree, err := s.categoryRepository.FindDescendantIDs(id)
+			if err != nil {
+				return nil, err
+			}
+			if slices.Contains(subtree, *input.ParentID) {
+				log.Println("Update category failed : Category ID = ", id, ", Parent ID = ", *input.ParentID, ", Error = ", ErrInvalidCategoryParent)
+				return nil, ErrInvalidCategoryParent
+			}
+			category.ParentID = input.ParentID
+		}
+	}
+
+	if err := s.categoryRepository.Update(category); err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// Delete removes a leaf category. Items in the category are left without category.
+func (s *CategoryService) Delete(id uint) error {
+	category, err := s.categoryRepository.FindById(id)
+	if err != nil {
+		return err
+	}
+	if len(category.Children) > 0 {
+		return ErrCategoryHasChildren
+	}
+	log.Println("Delete category success : Category ID = ", id)
+	return s.categoryRepository.Delete(id)
+}
+
+func (s *CategoryService) FindAllTags() ([]models.Tag, error) {
+	return s.tagRepository.FindAll()
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB connects to SERVICES_TEST_DATABASE_DSN, migrates the tables and empties the ones in clear.
+// The test is skipped when the variable is not set.
+func openTestDB(t *testing.T, tables []interface{}, clear ...interface{}) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("SERVICES_TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("SERVICES_TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range clear {
+		if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"gin-freemarket/utils/imaging"
+	"gin-freemarket/utils/storage"
+	"log"
+	"net/http"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxImageSize      = 5 << 20 // 5MB
+	MaxImagePixels    = 24_000_000
+	MaxImagesPerItem  = 10
+	ThumbnailMaxPixel = 320
+)
+
+// supported image types and the file extension used for their keys.
+// the type is sniffed from the content, the Content-Type sent by the client is ignored.
+var imageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+var (
+	ErrNotItemOwner         = errors.New("you are not authorized to change this item")
+	ErrImageTooLarge        = fmt.Errorf("image must be smaller than %d bytes", MaxImageSize)
+	ErrTooManyPixels        = fmt.Errorf("image must have at most %d pixels", MaxImagePixels)
+	ErrUnsupportedImageType = errors.New("image must be a JPEG, PNG or GIF")
+	ErrTooManyImages        = fmt.Errorf("an item can have at most %d images", MaxImagesPerItem)
+	ErrInvalidImageOrder    = errors.New("image order must list every image of the item exactly once")
+)
+
+type IItemImageService interface {
+	Upload(itemID uint, user *models.User, data []byte) (*models.ItemImage, error)
+	Delete(itemID uint, imageID uint, user *models.User) error
+	Reorder(itemID uint, imageIDs []uint, user *models.User) ([]models.ItemImage, error)
+}
+
+type ItemImageService struct {
+	itemRepository      repositories.IItemRepository
+	itemImageRepository repositories.IItemImageRepository
+	blobStore           storage.BlobStore
+	db                  *gorm.DB
+}
+
+func NewItemImageService(
+	itemRepository repositories.IItemRepository,
+	itemImageRepository repositories.IItemImageRepository,
+	blobStore storage.BlobStore,
+	db *gorm.DB,
+) IItemImageService {
+	return &ItemImageService{
+		itemRepository:      itemRepository,
+		itemImageRepository: itemImageRepository,
+		blobStore:           blobStore,
+		db:                  db,
+	}
+}
+
+func (s *ItemImageService) Upload(itemID uint, user *models.User, data []byte) (*models.ItemImage, error) {
+	item, err := s.findOwnedItem(itemID, user)
+	if err != nil {
+		return nil, err
+	}
+	// checked again under the item lock when the image is saved
+	if len(item.Images) >= MaxImagesPerItem {
+		return nil, ErrTooManyImages
+	}
+	if len(data) > MaxImageSize {
+		return nil, ErrImageTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	extension, ok := imageExtensions[contentType]
+	if !ok {
+		log.Println("Upload image failed : Item ID = ", itemID, ", Content-Type = ", contentType)
+		return nil, ErrUnsupportedImageType
+	}
+
+	img, err := imaging.Decode(data, MaxImagePixels)
+	if err != nil {
+		log.Println("Upload image failed : Item ID = ", itemID, ", Error = ", err)
+		if errors.Is(err, imaging.ErrTooManyPixels) {
+			return nil, ErrTooManyPixels
+		}
+		return nil, ErrUnsupportedImageType
+	}
+	thumbnail, err := imaging.Thumbnail(img, ThumbnailMaxPixel)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return nil, err
+	}
+	image := &models.ItemImage{
+		ItemID:       itemID,
+		Key:          fmt.Sprintf("items/%d/%s.%s", itemID, name, extension),
+		ThumbnailKey: fmt.Sprintf("items/%d/%s_thumb.jpg", itemID, name),
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		Width:        img.Bounds().Dx(),
+		Height:       img.Bounds().Dy(),
+	}
+
+	ctx := context.Background()
+	if err := s.blobStore.Put(ctx, image.Key, data, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := s.blobStore.Put(ctx, image.ThumbnailKey, thumbnail, "image/jpeg"); err != nil {
+		s.deleteBlobs(image)
+		return nil, fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// concurrent uploads to the item wait for the lock, so the count is exact
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Item{}, itemID).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.ItemImage{}).Where("item_id = ?", itemID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MaxImagesPerItem {
+			return ErrTooManyImages
+		}
+		return repositories.NewItemImageRepository(tx).Create(image)
+	})
+	if err != nil {
+		s.deleteBlobs(image)
+		return nil, err
+	}
+
+	image.URL = s.blobStore.URL(image.Key)
+	image.ThumbnailURL = s.blobStore.URL(image.ThumbnailKey)
+	log.Println("Upload image success : Item ID = ", itemID, ", Image ID = ", image.ID)
+	return image, nil
+}
+
+func (s *ItemImageService) Delete(itemID uint, imageID uint, user *models.User) error {
+	if _, err := s.findOwnedItem(itemID, user); err != nil {
+		return err
+	}
+	image, err := s.itemImageRepository.FindById(itemID, imageID)
+	if err != nil {
+		return err
+	}
+	if err := s.itemImageRepository.Delete(image.ID); err != nil {
+		return err
+	}
+	// the row is gone, a blob left behind is only wasted space
+	s.deleteBlobs(image)
+	log.Println("Delete image success : Item ID = ", itemID, ", Image ID = ", imageID)
+	return nil
+}
+
+func (s *ItemImageService) Reorder(itemID uint, imageIDs []uint, user *models.User) ([]models.ItemImage, error) {
+	item, err := s.findOwnedItem(itemID, user)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make([]uint, len(item.Images))
+	for i, image := range item.Images {
+		current[i] = image.ID
+	}
+	requested := slices.Clone(imageIDs)
+	slices.Sort(current)
+	slices.Sort(requested)
+	if !slices.Equal(current, requested) {
+		return nil, ErrInvalidImageOrder
+	}
+
+	if err := s.itemImageRepository.UpdatePositions(itemID, imageIDs); err != nil {
+		return nil, err
+	}
+	images, err := s.itemImageRepository.FindByItemID(itemID)
+	if err != nil {
+		return nil, err
+	}
+	attachImageURLs(s.blobStore, images)
+	return images, nil
+}
+
+// findOwnedItem loads the item when the user may change it, see canManageItem.
+func (s *ItemImageService) findOwnedItem(itemID uint, user *models.User) (*models.Item, error) {
+	item, err := s.itemRepository.FindById(itemID)
+	if err != nil {
+		return nil, err
+	}
+	if !canManageItem(&item, user) {
+		log.Println("Item image change failed : Item ID = ", itemID, ", User ID = ", user.ID, ", Error = ", ErrNotItemOwner)
+		return nil, ErrNotItemOwner
+	}
+	return &item, nil
+}
+
+func (s *ItemImageService) deleteBlobs(image *models.ItemImage) {
+	ctx := context.Background()
+	for _, key := range []string{image.Key, image.ThumbnailKey} {
+		if err := s.blobStore.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrBlobNotFound) {
+			log.Println("Delete blob failed : Key = ", key, ", Error = ", err)
+		}
+	}
+}
+
+// attachImageURLs fills the public URLs of the images.
+func attachImageURLs(blobStore storage.BlobStore, images []models.ItemImage) {
+	for i := range images {
+		images[i].URL = blobStore.URL(images[i].Key)
+		images[i].ThumbnailURL = blobStore.URL(images[i].ThumbnailKey)
+	}
+}
+
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,42 +1,194 @@
 package services
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"gin-freemarket/dto"
 	"gin-freemarket/models"
 	"gin-freemarket/repositories"
+	"gin-freemarket/utils/storage"
+	"html"
 	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"gorm.io/gorm"
 )
 
+const (
+	DefaultItemPageSize = 20
+	DefaultItemSort     = "created_at"
+)
+
+var (
+	ErrInvalidCursor    = errors.New("invalid cursor")
+	ErrEmptySearchQuery = errors.New("search query has no searchable words")
+	ErrCategoryNotFound = errors.New("category not found")
+)
+
 type IItemService interface {
-	FindAll() ([]models.Item, error)
+	FindAll(query dto.ItemListQuery) (*dto.ItemListResponse, error)
+	Search(query dto.ItemSearchQuery) (*dto.ItemSearchResponse, error)
 	FindById(id uint) (models.Item, error)
 	Create(item dto.CreateItemInput, userId uint) (*models.Item, error)
-	Update(id uint, item dto.UpdateItemInput, userId uint) (*models.Item, error)
-	Delete(id uint, userId uint) error
+	// Update and Delete are allowed to the owner of the item and to users who may manage any item
+	Update(id uint, item dto.UpdateItemInput, user *models.User) (*models.Item, error)
+	Delete(id uint, user *models.User) error
 	DeductItemQuantity(itemID uint, quantity uint) error
 }
 
 type ItemService struct {
-	itemRepository repositories.IItemRepository
-	db             *gorm.DB
+	itemRepository     repositories.IItemRepository
+	categoryRepository repositories.ICategoryRepository
+	tagRepository      repositories.ITagRepository
+	blobStore          storage.BlobStore
+	db                 *gorm.DB
 }
 
-func NewItemService(itemRepository repositories.IItemRepository, db *gorm.DB) IItemService {
-	return &ItemService{itemRepository: itemRepository, db: db}
+func NewItemService(
+	itemRepository repositories.IItemRepository,
+	categoryRepository repositories.ICategoryRepository,
+	tagRepository repositories.ITagRepository,
+	blobStore storage.BlobStore,
+	db *gorm.DB,
+) IItemService {
+	return &ItemService{
+		itemRepository:     itemRepository,
+		categoryRepository: categoryRepository,
+		tagRepository:      tagRepository,
+		blobStore:          blobStore,
+		db:                 db,
+	}
 }
 
-func (s *ItemService) FindAll() ([]models.Item, error) {
-	return s.itemRepository.FindAll()
+func (s *ItemService) FindAll(query dto.ItemListQuery) (*dto.ItemListResponse, error) {
+	if query.Limit == 0 {
+		query.Limit = DefaultItemPageSize
+	}
+	if query.Sort == "" {
+		query.Sort = DefaultItemSort
+	}
+	if query.Order != "desc" {
+		query.Order = "asc"
+	}
+
+	itemQuery := repositories.ItemQuery{
+		MinPrice:    query.MinPrice,
+		MaxPrice:    query.MaxPrice,
+		UserID:      query.UserID,
+		SoldOut:     query.SoldOut,
+		MinQuantity: query.MinQuantity,
+		CategoryID:  query.CategoryID,
+		Tags:        normalizeTags(query.Tags),
+		SortBy:      query.Sort,
+		Desc:        query.Order == "desc",
+		// fetch one extra row to know whether there is a next page
+		Limit: query.Limit + 1,
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeItemCursor(query.Cursor, query.Sort, query.Order)
+		if err != nil {
+			log.Println("FindAll failed : cursor = ", query.Cursor, ", Error = ", err)
+			return nil, ErrInvalidCursor
+		}
+		itemQuery.After = cursor
+		query.Page = 0
+	} else {
+		if query.Page == 0 {
+			query.Page = 1
+		}
+		itemQuery.Offset = (query.Page - 1) * query.Limit
+	}
+
+	items, total, err := s.itemRepository.Search(itemQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		attachImageURLs(s.blobStore, items[i].Images)
+	}
+
+	response := &dto.ItemListResponse{
+		Items:      items,
+		TotalCount: total,
+		Page:       query.Page,
+		Limit:      query.Limit,
+	}
+	if len(items) > query.Limit {
+		response.Items = items[:query.Limit]
+		response.NextCursor = encodeItemCursor(response.Items[query.Limit-1], query.Sort, query.Order)
+	}
+	return response, nil
+}
+
+func (s *ItemService) Search(query dto.ItemSearchQuery) (*dto.ItemSearchResponse, error) {
+	if query.Limit == 0 {
+		query.Limit = DefaultItemPageSize
+	}
+	if query.Page == 0 {
+		query.Page = 1
+	}
+
+	textQuery := parseItemTextQuery(query.Q)
+	if len(textQuery.Terms) == 0 && len(textQuery.Phrases) == 0 && len(textQuery.Prefixes) == 0 {
+		return nil, ErrEmptySearchQuery
+	}
+	textQuery.Limit = query.Limit
+	textQuery.Offset = (query.Page - 1) * query.Limit
+
+	hits, total, err := s.itemRepository.FullTextSearch(textQuery)
+	if err != nil {
+		log.Println("Search failed : q = ", query.Q, ", Error = ", err)
+		return nil, err
+	}
+
+	results := make([]dto.ItemSearchResult, len(hits))
+	for i, hit := range hits {
+		attachImageURLs(s.blobStore, hit.Images)
+		results[i] = dto.ItemSearchResult{
+			Item: hit.Item,
+			Rank: hit.Rank,
+			Highlights: dto.ItemSearchHighlight{
+				Name:        highlightHTML(hit.NameHighlight),
+				Description: highlightHTML(hit.DescriptionHighlight),
+			},
+		}
+	}
+	return &dto.ItemSearchResponse{
+		Results:    results,
+		TotalCount: total,
+		Page:       query.Page,
+		Limit:      query.Limit,
+	}, nil
 }
 
 func (s *ItemService) FindById(id uint) (models.Item, error) {
-	return s.itemRepository.FindById(id)
+	item, err := s.itemRepository.FindById(id)
+	if err != nil {
+		return models.Item{}, err
+	}
+	attachImageURLs(s.blobStore, item.Images)
+	return item, nil
 }
 
 func (s *ItemService) Create(item dto.CreateItemInput, userId uint) (*models.Item, error) {
+	if item.CategoryID != nil {
+		if _, err := s.categoryRepository.FindById(*item.CategoryID); err != nil {
+			log.Println("Create failed : Category ID = ", *item.CategoryID, ", Error = ", err)
+			return nil, ErrCategoryNotFound
+		}
+	}
+
+	tags, err := s.tagRepository.FindOrCreate(normalizeTags(item.Tags))
+	if err != nil {
+		return nil, err
+	}
+
 	newItem := models.Item{
 		Name:        item.Name,
 		Price:       item.Price,
@@ -44,19 +196,21 @@ func (s *ItemService) Create(item dto.CreateItemInput, userId uint) (*models.Ite
 		SoldOut:     false,
 		Quantity:    item.Quantity,
 		UserID:      userId,
+		CategoryID:  item.CategoryID,
+		Tags:        tags,
 	}
 	return s.itemRepository.Create(newItem, userId)
 }
 
-func (s *ItemService) Update(id uint, item dto.UpdateItemInput, userId uint) (*models.Item, error) {
+func (s *ItemService) Update(id uint, item dto.UpdateItemInput, user *models.User) (*models.Item, error) {
 	targetItem, err := s.itemRepository.FindById(id)
 	if err != nil {
 		return nil, err
 	}
 
-	if targetItem.UserID != userId {
-		log.Println("Update failed : Item ID = ", id, ", User ID = ", userId, ", Error = ", "you are not authorized to update this item")
-		return nil, errors.New("you are not authorized to update this item")
+	if !canManageItem(&targetItem, user) {
+		log.Println("Update failed : Item ID = ", id, ", User ID = ", user.ID, ", Error = ", ErrNotItemOwner)
+		return nil, ErrNotItemOwner
 	}
 
 	if item.Name != nil {
@@ -74,24 +228,69 @@ func (s *ItemService) Update(id uint, item dto.UpdateItemInput, userId uint) (*m
 	if item.Quantity != nil {
 		targetItem.Quantity = *item.Quantity
 	}
-	return s.itemRepository.Update(id, targetItem)
+	if item.CategoryID != nil {
+		if *item.CategoryID == 0 {
+			targetItem.CategoryID = nil
+		} else {
+			if _, err := s.categoryRepository.FindById(*item.CategoryID); err != nil {
+				log.Println("Update failed : Item ID = ", id, ", Category ID = ", *item.CategoryID, ", Error = ", err)
+				return nil, ErrCategoryNotFound
+			}
+			targetItem.CategoryID = item.CategoryID
+		}
+		targetItem.Category = nil
+	}
+
+	// the fields and the tags are changed together or not at all
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		itemRepository := repositories.NewItemRepository(tx)
+		if _, err := itemRepository.Update(id, targetItem); err != nil {
+			return err
+		}
+
+		if item.Tags != nil {
+			tags, err := repositories.NewTagRepository(tx).FindOrCreate(normalizeTags(item.Tags))
+			if err != nil {
+				return err
+			}
+			if err := itemRepository.ReplaceTags(id, tags); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Update failed : Item ID = ", id, ", Error = ", err)
+		return nil, err
+	}
+
+	updatedItem, err := s.FindById(id)
+	if err != nil {
+		return nil, err
+	}
+	return &updatedItem, nil
 }
 
-func (s *ItemService) Delete(id uint, userId uint) error {
+func (s *ItemService) Delete(id uint, user *models.User) error {
 	targetItem, err := s.itemRepository.FindById(id)
 	if err != nil {
 		return err
 	}
 
-	if targetItem.UserID != userId {
-		log.Println("Delete failed : Item ID = ", id, ", User ID = ", userId, ", Error = ", "you are not authorized to delete this item")
-		return errors.New("you are not authorized to delete this item")
+	if !canManageItem(&targetItem, user) {
+		log.Println("Delete failed : Item ID = ", id, ", User ID = ", user.ID, ", Error = ", ErrNotItemOwner)
+		return ErrNotItemOwner
 	}
 
-	log.Println("Delete success : Item ID = ", id, ", User ID = ", userId)
+	log.Println("Delete success : Item ID = ", id, ", User ID = ", user.ID)
 	return s.itemRepository.Delete(id)
 }
 
+// canManageItem is true for the owner of the item and for admins.
+func canManageItem(item *models.Item, user *models.User) bool {
+	return item.UserID == user.ID || user.HasPermission(models.PermissionItemsManageAny)
+}
+
 func (s *ItemService) DeductItemQuantity(itemID uint, quantity uint) error {
 	return s.itemRepository.DeductItemQuantity(itemID, quantity)
 }
@@ -148,3 +347,111 @@ func (s *ItemService) Purchase(input dto.PurchaseItemInput) error {
 	log.Println("Purchase success : Item ID = ", input.ItemID, ", Quantity = ", input.Quantity)
 	return nil
 }
+
+// itemCursor is the JSON payload behind the opaque cursor string handed to clients.
+// Sort and Order record the ordering the cursor was produced for.
+type itemCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+}
+
+func encodeItemCursor(item models.Item, sortBy string, order string) string {
+	cursor := itemCursor{ID: item.ID, Sort: sortBy, Order: order}
+	switch sortBy {
+	case "price":
+		cursor.Value = strconv.FormatUint(uint64(item.Price), 10)
+	case "created_at":
+		cursor.Value = item.CreatedAt.Format(time.RFC3339Nano)
+	case "name":
+		cursor.Value = item.Name
+	}
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// decodeItemCursor converts the cursor back into the typed sort value expected by the repository.
+// A cursor produced for another sort column or direction is rejected.
+func decodeItemCursor(encoded string, sortBy string, order string) (*repositories.ItemCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var cursor itemCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != sortBy || cursor.Order != order {
+		return nil, errors.New("cursor was produced for sort=" + cursor.Sort + "&order=" + cursor.Order)
+	}
+
+	var value interface{}
+	switch sortBy {
+	case "price":
+		value, err = strconv.ParseUint(cursor.Value, 10, 64)
+	case "created_at":
+		value, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	default:
+		value = cursor.Value
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &repositories.ItemCursor{Value: value, ID: cursor.ID}, nil
+}
+
+var highlightReplacer = strings.NewReplacer(repositories.HighlightStart, "<mark>", repositories.HighlightStop, "</mark>")
+
+// highlightHTML escapes a snippet and marks its matches with <mark>, the only markup in the result.
+func highlightHTML(snippet string) string {
+	return highlightReplacer.Replace(html.EscapeString(snippet))
+}
+
+// parseItemTextQuery splits a raw search string into "quoted phrases", prefix* terms and plain words.
+// Prefix terms are reduced to letters and digits because they are passed to to_tsquery, which has its own syntax.
+func parseItemTextQuery(raw string) repositories.ItemTextQuery {
+	var query repositories.ItemTextQuery
+
+	parts := strings.Split(raw, "\"")
+	for i, part := range parts {
+		// odd segments are inside quotes, an unterminated quote is treated as a phrase as well
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			if strings.HasSuffix(word, "*") {
+				prefix := strings.Map(func(r rune) rune {
+					if unicode.IsLetter(r) || unicode.IsDigit(r) {
+						return r
+					}
+					return -1
+				}, word)
+				if prefix != "" {
+					query.Prefixes = append(query.Prefixes, prefix)
+				}
+				continue
+			}
+			query.Terms = append(query.Terms, word)
+		}
+	}
+	return query
+}
+
+// normalizeTags lower-cases and trims tag names and drops empty and duplicated ones.
+func normalizeTags(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		tag := strings.ToLower(strings.TrimSpace(name))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/env"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrCancelWindowExpired = errors.New("the cancellation period for this order has ended")
+
+// LoadBuyerCancelWindow reads ORDER_CANCEL_WINDOW_MINUTES, the time after ordering during which
+// the buyer may cancel. Defaults to 30 minutes.
+func LoadBuyerCancelWindow() time.Duration {
+	return time.Duration(env.Int("ORDER_CANCEL_WINDOW_MINUTES", 30)) * time.Minute
+}
+
+// cancelOrder cancels the locked order on behalf of the user, puts the ordered quantities back in stock
+// and returns the money to the buyer.
+// The buyer may cancel within buyerWindow after ordering, the seller any time before shipment.
+// Other users get gorm.ErrRecordNotFound so that orders of other users are not revealed.
+func cancelOrder(tx *gorm.DB, order *models.Order, userID uint, reason string, buyerWindow time.Duration) error {
+	switch {
+	case isOrderSeller(order, userID):
+		// sellers are only limited by the state machine: no cancellation after shipment
+	case order.UserID == userID:
+		if time.Since(order.CreatedAt) > buyerWindow {
+			log.Println("Cancel order rejected : Order ID = ", order.ID, ", User ID = ", userID, ", Error = ", ErrCancelWindowExpired)
+			return ErrCancelWindowExpired
+		}
+	default:
+		return gorm.ErrRecordNotFound
+	}
+
+	return cancelLockedOrder(tx, order, &userID, reason)
+}
+
+// cancelLockedOrder cancels the locked order, puts the stock back and releases the payment.
+// changedBy is nil when the system cancels the order.
+func cancelLockedOrder(tx *gorm.DB, order *models.Order, changedBy *uint, reason string) error {
+	order.CancelReason = reason
+	if err := transitionOrder(tx, order, models.OrderStatusCancelled, changedBy, reason); err != nil {
+		return err
+	}
+	if err := restoreStock(tx, order.Lines); err != nil {
+		return err
+	}
+	return releasePayment(tx, order)
+}
+
+// restoreStock adds the quantities of the lines back to their items.
+// Items are locked in item ID order like placeOrder does.
+func restoreStock(tx *gorm.DB, lines []models.OrderLine) error {
+	quantities := make(map[uint]uint)
+	var itemIDs []uint
+	for _, line := range lines {
+		if _, ok := quantities[line.ItemID]; !ok {
+			itemIDs = append(itemIDs, line.ItemID)
+		}
+		quantities[line.ItemID] += uint(line.Quantity)
+	}
+	sort.Slice(itemIDs, func(i, j int) bool { return itemIDs[i] < itemIDs[j] })
+
+	for _, itemID := range itemIDs {
+		// the listing may have been deleted since, its stock is restored anyway
+		var item models.Item
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, itemID).Error; err != nil {
+			return fmt.Errorf("item not found: %d: %w", itemID, err)
+		}
+
+		item.Quantity += quantities[itemID]
+		item.SoldOut = false
+		if err := tx.Unscoped().Model(&item).Select("Quantity", "SoldOut").Updates(&item).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
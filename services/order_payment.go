@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/payments"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrPaymentFailed = errors.New("payment failed")
+
+// authorizeOrders sends the authorizations of orders placed together, once their transaction is committed.
+// When one of them is declined the others are cancelled as well, so that a checkout is paid in full or not at all,
+// and ErrPaymentFailed is returned. An authorization that cannot be sent now leaves its order pending,
+// the outbox retries it.
+func authorizeOrders(db *gorm.DB, outbox IPaymentOutbox, orders []*models.Order) error {
+	for _, order := range orders {
+		outbox.ProcessOrder(order.ID)
+	}
+
+	var declined int64
+	if err := db.Model(&models.Order{}).
+		Where("id IN ? AND status = ?", orderIDs(orders), models.OrderStatusCancelled).
+		Count(&declined).Error; err != nil {
+		return err
+	}
+	if declined == 0 {
+		return nil
+	}
+
+	for _, order := range orders {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			locked, err := lockOrder(tx, order.ID)
+			if err != nil {
+				return err
+			}
+			if !canTransitionOrder(locked.Status, models.OrderStatusCancelled) {
+				return nil
+			}
+			return cancelLockedOrder(tx, locked, nil, "payment of the checkout failed")
+		})
+		if err != nil {
+			log.Println("Cancel unpaid order failed : Order ID = ", order.ID, ", Error = ", err)
+			continue
+		}
+		outbox.ProcessOrder(order.ID)
+	}
+	return ErrPaymentFailed
+}
+
+func orderIDs(orders []*models.Order) []uint {
+	ids := make([]uint, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return ids
+}
+
+// applyAuthorization records the intent of a done authorization and moves the order from pending to paid.
+// The funds stay in escrow until the buyer confirms receipt.
+// An order cancelled before its authorization went through gets the hold released right away.
+func applyAuthorization(tx *gorm.DB, order *models.Order, intent *payments.PaymentIntent) error {
+	order.PaymentIntentID = intent.ID
+	order.PaymentStatus = string(intent.Status)
+	if order.Status == models.OrderStatusPending {
+		return transitionOrder(tx, order, models.OrderStatusPaid, nil, "payment authorized")
+	}
+
+	if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).
+		Updates(map[string]interface{}{"payment_intent_id": order.PaymentIntentID, "payment_status": order.PaymentStatus}).Error; err != nil {
+		return err
+	}
+	if order.Status != models.OrderStatusCancelled {
+		log.Println("Payment authorized for order that is not pending : Order ID = ", order.ID, ", Status = ", order.Status)
+		return nil
+	}
+	return releasePayment(tx, order)
+}
+
+// capturePayment takes the held funds when the buyer has received the order.
+// The capture is sent to the provider once the transaction is committed, see PaymentOutbox.
+func capturePayment(tx *gorm.DB, order *models.Order) error {
+	if order.PaymentIntentID == "" {
+		// orders migrated from purchases were never paid through a provider
+		return nil
+	}
+	return enqueuePaymentOperation(tx, order, models.PaymentActionCapture)
+}
+
+// releasePayment gives the money back to the buyer of a cancelled order:
+// an authorization is voided, a captured payment is refunded.
+// The operation is sent to the provider once the transaction is committed, see PaymentOutbox.
+func releasePayment(tx *gorm.DB, order *models.Order) error {
+	if order.PaymentIntentID == "" {
+		return nil
+	}
+
+	switch payments.PaymentStatus(order.PaymentStatus) {
+	case payments.PaymentStatusAuthorized:
+		return enqueuePaymentOperation(tx, order, models.PaymentActionVoid)
+	case payments.PaymentStatusCaptured:
+		return enqueuePaymentOperation(tx, order, models.PaymentActionRefund)
+	default:
+		return nil
+	}
+}
+
+// enqueuePaymentOperation records the operation in the transaction of the order change.
+// The key is derived from the order, so the provider applies an action at most once per order.
+func enqueuePaymentOperation(tx *gorm.DB, order *models.Order, action models.PaymentAction) error {
+	now := time.Now()
+	return tx.Create(&models.PaymentOperation{
+		OrderID:        order.ID,
+		Action:         action,
+		IdempotencyKey: paymentIdempotencyKey(order, action),
+		Status:         models.PaymentOperationPending,
+		NextAttemptAt:  &now,
+	}).Error
+}
+
+func paymentIdempotencyKey(order *models.Order, action models.PaymentAction) string {
+	return order.OrderNumber + ":" + string(action)
+}
+
+func savePaymentStatus(tx *gorm.DB, order *models.Order, status payments.PaymentStatus) error {
+	order.PaymentStatus = string(status)
+	return tx.Model(&models.Order{}).Where("id = ?", order.ID).Update("payment_status", order.PaymentStatus).Error
+}
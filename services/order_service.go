@@ -0,0 +1,296 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"gin-freemarket/utils/env"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderPricing holds the charges added on top of the item subtotal.
+type OrderPricing struct {
+	// TaxRatePercent is applied to the subtotal and rounded down
+	TaxRatePercent int
+	// ShippingFee is charged once per order
+	ShippingFee int
+}
+
+// LoadOrderPricing reads ORDER_TAX_RATE_PERCENT and ORDER_SHIPPING_FEE, both default to 0.
+func LoadOrderPricing() OrderPricing {
+	return OrderPricing{
+		TaxRatePercent: env.Int("ORDER_TAX_RATE_PERCENT", 0),
+		ShippingFee:    env.Int("ORDER_SHIPPING_FEE", 0),
+	}
+}
+
+// apply fills the amount breakdown of the order from the subtotal of its lines.
+func (p OrderPricing) apply(order *models.Order) {
+	order.Subtotal = 0
+	for _, line := range order.Lines {
+		order.Subtotal += line.TotalPrice
+	}
+	order.Tax = order.Subtotal * p.TaxRatePercent / 100
+	order.ShippingFee = p.ShippingFee
+	order.Discount = min(order.Discount, order.Subtotal+order.Tax+order.ShippingFee)
+	order.TotalPrice = order.Subtotal + order.Tax + order.ShippingFee - order.Discount
+}
+
+// orderLineRequest is one item and quantity to buy.
+type orderLineRequest struct {
+	ItemID   uint
+	Quantity uint
+}
+
+// placeOrder creates a pending order for the lines inside the given transaction and records the authorization
+// of its payment, which is sent to the provider after the commit, see authorizeOrders.
+// Item rows are locked with SELECT ... FOR UPDATE in item ID order, so concurrent orders
+// sharing items always wait for each other in the same order and cannot deadlock.
+// Any line that cannot be fulfilled fails the whole order with ErrItemOutOfStock.
+func placeOrder(tx *gorm.DB, pricing OrderPricing, userID uint, requests []orderLineRequest) (*models.Order, error) {
+	requests = append([]orderLineRequest(nil), requests...)
+	sort.Slice(requests, func(i, j int) bool { return requests[i].ItemID < requests[j].ItemID })
+
+	orderNumber, err := generateOrderNumber()
+	if err != nil {
+		return nil, err
+	}
+	order := &models.Order{
+		OrderNumber: orderNumber,
+		UserID:      userID,
+		Status:      models.OrderStatusPending,
+	}
+
+	for _, request := range requests {
+		// Get item with exclusive lock
+		// SELECT * from items where id = ? for update
+		var item models.Item
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, request.ItemID).Error; err != nil {
+			return nil, fmt.Errorf("item not found: %d: %w", request.ItemID, err)
+		}
+
+		if item.SoldOut || item.Quantity < request.Quantity {
+			log.Println("Place order failed : User ID = ", userID, ", Item ID = ", item.ID, ", Error = ", ErrItemOutOfStock)
+			return nil, fmt.Errorf("%w: item %d", ErrItemOutOfStock, item.ID)
+		}
+
+		item.Quantity -= request.Quantity
+		if item.Quantity == 0 {
+			item.SoldOut = true
+		}
+		if err := tx.Model(&item).Select("Quantity", "SoldOut").Updates(&item).Error; err != nil {
+			return nil, err
+		}
+
+		order.Lines = append(order.Lines, models.OrderLine{
+			ItemID:     item.ID,
+			Price:      int(item.Price),
+			Quantity:   int(request.Quantity),
+			TotalPrice: int(item.Price * request.Quantity),
+		})
+	}
+
+	pricing.apply(order)
+	if err := tx.Create(order).Error; err != nil {
+		return nil, err
+	}
+	if err := recordOrderStatus(tx, order.ID, "", order.Status, &userID, "order placed"); err != nil {
+		return nil, err
+	}
+
+	if err := enqueuePaymentOperation(tx, order, models.PaymentActionAuthorize); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// generateOrderNumber returns a human readable order number such as ORD-20240102-1A2B3C4D5E.
+func generateOrderNumber() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("ORD-%s-%X", time.Now().Format("20060102"), b), nil
+}
+
+var ErrNotOrderSeller = errors.New("you are not the seller of this order")
+
+type IOrderService interface {
+	FindAll(userID uint) ([]*dto.OrderResponse, error)
+	FindById(userID uint, id uint) (*dto.OrderResponse, error)
+	FindSales(sellerID uint) ([]*dto.OrderResponse, error)
+	FindHistory(userID uint, id uint) ([]dto.OrderStatusHistoryResponse, error)
+	Ship(sellerID uint, id uint, input dto.ShipOrderInput) (*dto.OrderResponse, error)
+	MarkDelivered(sellerID uint, id uint) (*dto.OrderResponse, error)
+	ConfirmReceipt(buyerID uint, id uint) (*dto.OrderResponse, error)
+	Cancel(userID uint, id uint, input dto.CancelOrderInput) (*dto.OrderResponse, error)
+}
+
+type OrderService struct {
+	orderRepository repositories.IOrderRepository
+	paymentOutbox   IPaymentOutbox
+	buyerWindow     time.Duration
+	db              *gorm.DB
+}
+
+func NewOrderService(orderRepository repositories.IOrderRepository, paymentOutbox IPaymentOutbox, db *gorm.DB) IOrderService {
+	return &OrderService{
+		orderRepository: orderRepository,
+		paymentOutbox:   paymentOutbox,
+		buyerWindow:     LoadBuyerCancelWindow(),
+		db:              db,
+	}
+}
+
+func (s *OrderService) FindAll(userID uint) ([]*dto.OrderResponse, error) {
+	orders, err := s.orderRepository.FindAll(userID)
+	if err != nil {
+		return nil, err
+	}
+	return toOrderResponses(orders), nil
+}
+
+func (s *OrderService) FindById(userID uint, id uint) (*dto.OrderResponse, error) {
+	order, err := s.orderRepository.FindById(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return dto.ToOrderResponse(order), nil
+}
+
+// FindSales returns the orders containing items sold by the user.
+func (s *OrderService) FindSales(sellerID uint) ([]*dto.OrderResponse, error) {
+	orders, err := s.orderRepository.FindAllBySeller(sellerID)
+	if err != nil {
+		return nil, err
+	}
+	return toOrderResponses(orders), nil
+}
+
+// FindHistory returns the status changes of the order to its buyer or seller.
+func (s *OrderService) FindHistory(userID uint, id uint) ([]dto.OrderStatusHistoryResponse, error) {
+	order, err := s.orderRepository.FindByIdWithoutOwner(id)
+	if err != nil {
+		return nil, err
+	}
+	if order.UserID != userID && !isOrderSeller(order, userID) {
+		// do not reveal orders of other users
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	history, err := s.orderRepository.FindHistory(id)
+	if err != nil {
+		return nil, err
+	}
+	return dto.ToOrderStatusHistoryResponses(history), nil
+}
+
+// Ship is called by the seller when the parcel has been handed to the carrier.
+func (s *OrderService) Ship(sellerID uint, id uint, input dto.ShipOrderInput) (*dto.OrderResponse, error) {
+	return s.changeStatus(id, func(tx *gorm.DB, order *models.Order) error {
+		if !isOrderSeller(order, sellerID) {
+			return ErrNotOrderSeller
+		}
+		order.TrackingNumber = input.TrackingNumber
+		return transitionOrder(tx, order, models.OrderStatusShipped, &sellerID, "tracking number: "+input.TrackingNumber)
+	})
+}
+
+// MarkDelivered is called by the seller when the carrier reports the delivery.
+func (s *OrderService) MarkDelivered(sellerID uint, id uint) (*dto.OrderResponse, error) {
+	return s.changeStatus(id, func(tx *gorm.DB, order *models.Order) error {
+		if !isOrderSeller(order, sellerID) {
+			return ErrNotOrderSeller
+		}
+		return transitionOrder(tx, order, models.OrderStatusDelivered, &sellerID, "")
+	})
+}
+
+// ConfirmReceipt is called by the buyer after receiving the order. It completes the order and captures the payment.
+// An order the seller has not marked as delivered goes through delivered first.
+func (s *OrderService) ConfirmReceipt(buyerID uint, id uint) (*dto.OrderResponse, error) {
+	return s.changeStatus(id, func(tx *gorm.DB, order *models.Order) error {
+		if order.UserID != buyerID {
+			return gorm.ErrRecordNotFound
+		}
+		if order.Status == models.OrderStatusShipped {
+			if err := transitionOrder(tx, order, models.OrderStatusDelivered, &buyerID, "receipt confirmed by buyer"); err != nil {
+				return err
+			}
+		}
+		if err := transitionOrder(tx, order, models.OrderStatusCompleted, &buyerID, "receipt confirmed by buyer"); err != nil {
+			return err
+		}
+		// release the escrow to the seller
+		return capturePayment(tx, order)
+	})
+}
+
+// Cancel cancels the order and restores the stock of its items.
+func (s *OrderService) Cancel(userID uint, id uint, input dto.CancelOrderInput) (*dto.OrderResponse, error) {
+	return s.changeStatus(id, func(tx *gorm.DB, order *models.Order) error {
+		return cancelOrder(tx, order, userID, input.Reason, s.buyerWindow)
+	})
+}
+
+// changeStatus locks the order, runs change in a transaction and returns the updated order.
+// Payment operations recorded by change are sent to the provider after the commit.
+func (s *OrderService) changeStatus(id uint, change func(tx *gorm.DB, order *models.Order) error) (*dto.OrderResponse, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	order, err := lockOrder(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := change(tx, order); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Println("Order status changed : Order ID = ", order.ID, ", Status = ", order.Status)
+	s.paymentOutbox.ProcessOrder(order.ID)
+
+	updated, err := s.orderRepository.FindByIdWithoutOwner(id)
+	if err != nil {
+		return nil, err
+	}
+	return dto.ToOrderResponse(updated), nil
+}
+
+// isOrderSeller reports whether every item of the order is sold by the user.
+// Lines must be loaded with their items.
+func isOrderSeller(order *models.Order, userID uint) bool {
+	if len(order.Lines) == 0 {
+		return false
+	}
+	for _, line := range order.Lines {
+		if line.Item.UserID != userID {
+			return false
+		}
+	}
+	return true
+}
+
+func toOrderResponses(orders []models.Order) []*dto.OrderResponse {
+	responses := make([]*dto.OrderResponse, len(orders))
+	for i, order := range orders {
+		responses[i] = dto.ToOrderResponse(&order)
+	}
+	return responses
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"log"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidOrderTransition = errors.New("order status cannot be changed")
+
+// orderTransitions is the order lifecycle: the statuses each status may move to.
+//
+//	pending -> paid -> shipped -> delivered -> completed
+//	pending, paid -> cancelled
+var orderTransitions = map[models.OrderStatus][]models.OrderStatus{
+	models.OrderStatusPending:   {models.OrderStatusPaid, models.OrderStatusCancelled},
+	models.OrderStatusPaid:      {models.OrderStatusShipped, models.OrderStatusCancelled},
+	models.OrderStatusShipped:   {models.OrderStatusDelivered},
+	models.OrderStatusDelivered: {models.OrderStatusCompleted},
+	models.OrderStatusCompleted: {},
+	models.OrderStatusCancelled: {},
+}
+
+func canTransitionOrder(from models.OrderStatus, to models.OrderStatus) bool {
+	return slices.Contains(orderTransitions[from], to)
+}
+
+// transitionOrder moves the order to the next status inside the transaction and appends the change to
+// order_status_history. The order must have been loaded with SELECT ... FOR UPDATE in the same transaction.
+// changedBy is nil for changes made by the system.
+func transitionOrder(tx *gorm.DB, order *models.Order, to models.OrderStatus, changedBy *uint, note string) error {
+	from := order.Status
+	if !canTransitionOrder(from, to) {
+		log.Println("Order transition rejected : Order ID = ", order.ID, ", From = ", from, ", To = ", to)
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidOrderTransition, from, to)
+	}
+
+	now := time.Now()
+	order.Status = to
+	switch to {
+	case models.OrderStatusShipped:
+		order.ShippedAt = &now
+	case models.OrderStatusDelivered:
+		order.DeliveredAt = &now
+	case models.OrderStatusCompleted:
+		order.CompletedAt = &now
+	case models.OrderStatusCancelled:
+		order.CancelledAt = &now
+		order.CancelledBy = changedBy
+	}
+
+	// the status in the WHERE clause guards against a concurrent change in case the row was not locked
+	result := tx.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, from).
+		Select("Status", "PaymentIntentID", "PaymentStatus", "TrackingNumber", "ShippedAt", "DeliveredAt", "CompletedAt", "CancelledAt", "CancelledBy", "CancelReason").
+		Updates(order)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: order %d was changed concurrently", ErrInvalidOrderTransition, order.ID)
+	}
+
+	return recordOrderStatus(tx, order.ID, from, to, changedBy, note)
+}
+
+// recordOrderStatus appends a row to order_status_history.
+func recordOrderStatus(tx *gorm.DB, orderID uint, from models.OrderStatus, to models.OrderStatus, changedBy *uint, note string) error {
+	return tx.Create(&models.OrderStatusHistory{
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+		Note:       note,
+	}).Error
+}
+
+// lockOrder loads the order with its lines and items and locks the order row until the transaction ends.
+func lockOrder(tx *gorm.DB, id uint) (*models.Order, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error; err != nil {
+		return nil, err
+	}
+	// deleted listings are loaded too, their seller still ships and cancels the order
+	unscoped := func(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+	if err := tx.Preload("Item", unscoped).Where("order_id = ?", id).Order("id").Find(&order.Lines).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/utils/env"
+	"gin-freemarket/utils/payments"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// a provider outage should be ridden out without hammering it, and a capture or refund
+	// should not wait for hours once it is back
+	paymentOutboxRetryBaseDelay = 15 * time.Second
+	paymentOutboxRetryMaxDelay  = 30 * time.Minute
+	paymentOutboxBatchSize      = 100
+)
+
+type IPaymentOutbox interface {
+	// ProcessOrder sends the pending operations of the order right away, failures are retried later.
+	ProcessOrder(orderID uint)
+	ProcessDue() error
+	StartRetryLoop(interval time.Duration)
+}
+
+// PaymentOutbox sends the payment operations recorded by order transactions to the provider.
+// An operation whose outcome is lost, e.g. because the commit after the provider call failed,
+// is sent again with the same idempotency key and is not applied twice.
+type PaymentOutbox struct {
+	gateway     payments.PaymentGateway
+	maxAttempts int
+	db          *gorm.DB
+}
+
+// NewPaymentOutbox reads the number of attempts per operation from PAYMENT_OUTBOX_MAX_ATTEMPTS (default 10).
+func NewPaymentOutbox(gateway payments.PaymentGateway, db *gorm.DB) IPaymentOutbox {
+	return &PaymentOutbox{
+		gateway:     gateway,
+		maxAttempts: env.Int("PAYMENT_OUTBOX_MAX_ATTEMPTS", 10),
+		db:          db,
+	}
+}
+
+// ProcessOrder also sends the operations recorded while processing, such as the void of an order cancelled
+// before its authorization went through.
+func (o *PaymentOutbox) ProcessOrder(orderID uint) {
+	var lastID uint
+	for {
+		var ids []uint
+		if err := o.db.Model(&models.PaymentOperation{}).
+			Where("order_id = ? AND status = ? AND id > ?", orderID, models.PaymentOperationPending, lastID).
+			Order("id").
+			Pluck("id", &ids).Error; err != nil {
+			log.Println("Process payment operations failed : Order ID = ", orderID, ", Error = ", err)
+			return
+		}
+		if len(ids) == 0 {
+			return
+		}
+		for _, id := range ids {
+			if err := o.process(id); err != nil {
+				log.Println("Process payment operation failed : ID = ", id, ", Error = ", err)
+			}
+		}
+		lastID = ids[len(ids)-1]
+	}
+}
+
+// ProcessDue sends the pending operations whose next attempt is due.
+func (o *PaymentOutbox) ProcessDue() error {
+	var ids []uint
+	if err := o.db.Model(&models.PaymentOperation{}).
+		Where("status = ? AND next_attempt_at <= ?", models.PaymentOperationPending, time.Now()).
+		Order("id").
+		Limit(paymentOutboxBatchSize).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := o.process(id); err != nil {
+			log.Println("Process payment operation failed : ID = ", id, ", Error = ", err)
+		}
+	}
+	return nil
+}
+
+// StartRetryLoop sends due operations in the background every interval.
+func (o *PaymentOutbox) StartRetryLoop(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := o.ProcessDue(); err != nil {
+				log.Printf("Error processing payment operations: %v", err)
+			}
+		}
+	}()
+}
+
+func (o *PaymentOutbox) process(id uint) error {
+	tx := o.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	// SKIP LOCKED lets several app instances work side by side without sending an operation twice at once
+	var operation models.PaymentOperation
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("id = ? AND status = ?", id, models.PaymentOperationPending).
+		First(&operation).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	order, err := lockOrder(tx, operation.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	operation.Attempts++
+	intent, err := o.send(order, &operation)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Payment operation failed : Order ID = ", order.ID, ", Action = ", operation.Action, ", Error = ", err)
+		// a declined authorization is final, retrying cannot change the answer
+		declined := operation.Action == models.PaymentActionAuthorize && errors.Is(err, payments.ErrPaymentDeclined)
+		if declined || operation.Attempts >= o.maxAttempts {
+			if failErr := o.fail(&operation, err); failErr != nil {
+				log.Println("Record payment operation failure failed : ID = ", operation.ID, ", Error = ", failErr)
+			}
+			return err
+		}
+		// count the failed attempt and retry later
+		o.db.Model(&models.PaymentOperation{}).Where("id = ?", operation.ID).Updates(map[string]interface{}{
+			"attempts":        operation.Attempts,
+			"last_error":      err.Error(),
+			"next_attempt_at": time.Now().Add(paymentOutboxRetryDelay(operation.Attempts)),
+		})
+		return err
+	}
+
+	if operation.Action == models.PaymentActionAuthorize {
+		err = applyAuthorization(tx, order, intent)
+	} else {
+		err = savePaymentStatus(tx, order, intent.Status)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	now := time.Now()
+	operation.Status = models.PaymentOperationDone
+	operation.ProcessedAt = &now
+	operation.NextAttemptAt = nil
+	operation.LastError = ""
+	if err := tx.Save(&operation).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Println("Payment operation done : Order ID = ", order.ID, ", Action = ", operation.Action)
+	return nil
+}
+
+// paymentOutboxRetryDelay doubles the delay with every attempt up to paymentOutboxRetryMaxDelay.
+func paymentOutboxRetryDelay(attempts int) time.Duration {
+	return retryDelay(attempts, paymentOutboxRetryBaseDelay, paymentOutboxRetryMaxDelay)
+}
+
+// fail gives up on the operation. An order whose authorization failed is cancelled and its stock put back,
+// other operations are left to an operator.
+func (o *PaymentOutbox) fail(operation *models.PaymentOperation, cause error) error {
+	log.Println("Payment operation gave up : ID = ", operation.ID, ", Order ID = ", operation.OrderID)
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		// the row lock is gone, another instance may have finished the operation meanwhile
+		result := tx.Model(&models.PaymentOperation{}).
+			Where("id = ? AND status = ?", operation.ID, models.PaymentOperationPending).
+			Updates(map[string]interface{}{
+				"status":          models.PaymentOperationFailed,
+				"attempts":        operation.Attempts,
+				"last_error":      cause.Error(),
+				"next_attempt_at": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || operation.Action != models.PaymentActionAuthorize {
+			return nil
+		}
+
+		order, err := lockOrder(tx, operation.OrderID)
+		if err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusPending {
+			return nil
+		}
+		return cancelUnpaidOrder(tx, order, payments.PaymentStatusFailed)
+	})
+}
+
+func (o *PaymentOutbox) send(order *models.Order, operation *models.PaymentOperation) (*payments.PaymentIntent, error) {
+	ctx := context.Background()
+	switch operation.Action {
+	case models.PaymentActionAuthorize:
+		return o.gateway.Authorize(ctx, payments.AuthorizeRequest{
+			Amount:         order.TotalPrice,
+			Currency:       payments.Currency(),
+			Reference:      order.OrderNumber,
+			IdempotencyKey: operation.IdempotencyKey,
+		})
+	case models.PaymentActionCapture:
+		return o.gateway.Capture(ctx, order.PaymentIntentID, operation.IdempotencyKey)
+	case models.PaymentActionVoid:
+		return o.gateway.Void(ctx, order.PaymentIntentID, operation.IdempotencyKey)
+	case models.PaymentActionRefund:
+		return o.gateway.Refund(ctx, order.PaymentIntentID, 0, operation.IdempotencyKey)
+	default:
+		return nil, fmt.Errorf("unknown payment action: %s", operation.Action)
+	}
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"gin-freemarket/utils/env"
+	"gin-freemarket/utils/payments"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+	ErrInvalidWebhookPayload   = errors.New("invalid webhook payload")
+)
+
+var (
+	// errWebhookNotReady means the event cannot be applied yet, e.g. the order is not committed yet
+	// or an earlier event of the same payment has not arrived. The event is retried later.
+	errWebhookNotReady = errors.New("webhook event cannot be applied yet")
+	// errWebhookNoChange means the event is valid but the order already reflects it
+	errWebhookNoChange = errors.New("webhook event has nothing to change")
+)
+
+const (
+	webhookRetryBaseDelay = 30 * time.Second
+	webhookRetryMaxDelay  = time.Hour
+	webhookRetryBatchSize = 100
+)
+
+type IPaymentWebhookService interface {
+	// Receive verifies, stores and applies a webhook. duplicate is true when the event was received before,
+	// the stored event is returned then and nothing is applied again.
+	Receive(payload []byte, signature string) (event *models.ProcessedWebhookEvent, duplicate bool, err error)
+	RetryDue() error
+	StartRetryLoop(interval time.Duration)
+}
+
+type PaymentWebhookService struct {
+	webhookEventRepository repositories.IWebhookEventRepository
+	secret                 string
+	maxAttempts            int
+	db                     *gorm.DB
+}
+
+// NewPaymentWebhookService reads the shared secret from PAYMENT_WEBHOOK_SECRET and the number of attempts
+// per event from PAYMENT_WEBHOOK_MAX_ATTEMPTS (default 10).
+func NewPaymentWebhookService(webhookEventRepository repositories.IWebhookEventRepository, db *gorm.DB) IPaymentWebhookService {
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		log.Println("PAYMENT_WEBHOOK_SECRET is not set, all payment webhooks will be rejected")
+	}
+	return &PaymentWebhookService{
+		webhookEventRepository: webhookEventRepository,
+		secret:                 secret,
+		maxAttempts:            env.Int("PAYMENT_WEBHOOK_MAX_ATTEMPTS", 10),
+		db:                     db,
+	}
+}
+
+func (s *PaymentWebhookService) Receive(payload []byte, signature string) (*models.ProcessedWebhookEvent, bool, error) {
+	if err := payments.VerifyWebhookSignature(payload, signature, s.secret, payments.DefaultWebhookTolerance, time.Now()); err != nil {
+		log.Println("Payment webhook rejected : Error = ", err)
+		return nil, false, ErrInvalidWebhookSignature
+	}
+	event, err := payments.ParseWebhookEvent(payload)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidWebhookPayload, err)
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, false, tx.Error
+	}
+
+	// the unique event_id makes a concurrent redelivery wait for this transaction and then insert nothing
+	record := &models.ProcessedWebhookEvent{
+		EventID:         event.ID,
+		Type:            string(event.Type),
+		PaymentIntentID: event.PaymentIntentID,
+		Payload:         string(payload),
+		Status:          models.WebhookEventPending,
+	}
+	result := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "event_id"}}, DoNothing: true}).Create(record)
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		log.Println("Duplicate payment webhook : Event ID = ", event.ID)
+		existing, err := s.webhookEventRepository.FindByEventID(event.ID)
+		return existing, true, err
+	}
+
+	if err := s.process(tx, record, event); err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return record, false, nil
+}
+
+// RetryDue applies the pending events whose next attempt is due.
+func (s *PaymentWebhookService) RetryDue() error {
+	ids, err := s.webhookEventRepository.FindDueIDs(time.Now(), webhookRetryBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.retry(id); err != nil {
+			log.Println("Retry payment webhook failed : ID = ", id, ", Error = ", err)
+		}
+	}
+	return nil
+}
+
+// StartRetryLoop retries pending events in the background every interval.
+func (s *PaymentWebhookService) StartRetryLoop(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.RetryDue(); err != nil {
+				log.Printf("Error retrying payment webhooks: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *PaymentWebhookService) retry(id uint) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	// SKIP LOCKED lets several app instances retry side by side without applying an event twice
+	var record models.ProcessedWebhookEvent
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("id = ? AND status = ?", id, models.WebhookEventPending).
+		First(&record).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	event, err := payments.ParseWebhookEvent([]byte(record.Payload))
+	if err != nil {
+		// the payload was parsed when it was received, so this only happens if the row was edited
+		record.Status = models.WebhookEventFailed
+		record.LastError = err.Error()
+		if err := tx.Save(&record).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+
+	if err := s.process(tx, &record, event); err != nil {
+		tx.Rollback()
+		// count the failed attempt so that a broken event does not block the queue forever
+		updates := map[string]interface{}{
+			"attempts":   record.Attempts,
+			"last_error": err.Error(),
+		}
+		if record.Attempts >= s.maxAttempts {
+			log.Println("Payment webhook gave up : Event ID = ", record.EventID, ", Error = ", err)
+			updates["status"] = models.WebhookEventFailed
+			updates["next_attempt_at"] = nil
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(webhookRetryDelay(record.Attempts))
+		}
+		s.db.Model(&models.ProcessedWebhookEvent{}).Where("id = ? AND status = ?", id, models.WebhookEventPending).Updates(updates)
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// process applies the event to its order and records the outcome on the stored event.
+// Errors other than errWebhookNotReady and errWebhookNoChange abort the transaction.
+func (s *PaymentWebhookService) process(tx *gorm.DB, record *models.ProcessedWebhookEvent, event *payments.WebhookEvent) error {
+	record.Attempts++
+	err := applyPaymentEvent(tx, event)
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		record.Status = models.WebhookEventProcessed
+		record.ProcessedAt = &now
+		record.NextAttemptAt = nil
+		record.LastError = ""
+	case errors.Is(err, errWebhookNoChange):
+		record.Status = models.WebhookEventIgnored
+		record.ProcessedAt = &now
+		record.NextAttemptAt = nil
+		record.LastError = err.Error()
+	case errors.Is(err, errWebhookNotReady):
+		record.LastError = err.Error()
+		if record.Attempts >= s.maxAttempts {
+			log.Println("Payment webhook gave up : Event ID = ", record.EventID, ", Error = ", err)
+			record.Status = models.WebhookEventFailed
+			record.NextAttemptAt = nil
+		} else {
+			next := now.Add(webhookRetryDelay(record.Attempts))
+			record.Status = models.WebhookEventPending
+			record.NextAttemptAt = &next
+		}
+	default:
+		return err
+	}
+	return tx.Save(record).Error
+}
+
+// webhookRetryDelay doubles the delay with every attempt up to webhookRetryMaxDelay.
+func webhookRetryDelay(attempts int) time.Duration {
+	return retryDelay(attempts, webhookRetryBaseDelay, webhookRetryMaxDelay)
+}
+
+// applyPaymentEvent moves the order of the payment forward.
+// The payment status already stored on the order decides whether the event is new, stale or early:
+//
+//	authorized -> captured -> refunded
+//	authorized -> voided, failed
+func applyPaymentEvent(tx *gorm.DB, event *payments.WebhookEvent) error {
+	if event.PaymentIntentID == "" {
+		return fmt.Errorf("%w: event without payment intent", errWebhookNoChange)
+	}
+	order, err := lockOrderByPaymentIntent(tx, event.PaymentIntentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: no order for payment intent %s", errWebhookNotReady, event.PaymentIntentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	current := payments.PaymentStatus(order.PaymentStatus)
+	switch event.Type {
+	case payments.WebhookEventPaymentAuthorized:
+		if order.Status != models.OrderStatusPending {
+			return fmt.Errorf("%w: order %d is %s", errWebhookNoChange, order.ID, order.Status)
+		}
+		order.PaymentStatus = string(payments.PaymentStatusAuthorized)
+		return transitionOrder(tx, order, models.OrderStatusPaid, nil, "payment authorized by provider")
+
+	case payments.WebhookEventPaymentCaptured:
+		switch current {
+		case payments.PaymentStatusAuthorized:
+			return savePaymentStatus(tx, order, payments.PaymentStatusCaptured)
+		case "":
+			return fmt.Errorf("%w: authorization of order %d not recorded", errWebhookNotReady, order.ID)
+		}
+
+	case payments.WebhookEventPaymentRefunded:
+		switch current {
+		case payments.PaymentStatusCaptured:
+			return savePaymentStatus(tx, order, payments.PaymentStatusRefunded)
+		case payments.PaymentStatusAuthorized, "":
+			return fmt.Errorf("%w: capture of order %d not recorded", errWebhookNotReady, order.ID)
+		}
+
+	case payments.WebhookEventPaymentFailed, payments.WebhookEventPaymentVoided:
+		switch current {
+		case payments.PaymentStatusAuthorized, "":
+			status := payments.PaymentStatusFailed
+			if event.Type == payments.WebhookEventPaymentVoided {
+				status = payments.PaymentStatusVoided
+			}
+			return cancelUnpaidOrder(tx, order, status)
+		}
+
+	default:
+		// kept for a later release that understands the type, until the attempts run out
+		return fmt.Errorf("%w: unknown event type %s", errWebhookNotReady, event.Type)
+	}
+
+	return fmt.Errorf("%w: payment of order %d is %s", errWebhookNoChange, order.ID, current)
+}
+
+// cancelUnpaidOrder cancels an order whose payment did not go through and puts the stock back.
+// Orders that are already on their way only get the payment status, a person has to sort them out.
+func cancelUnpaidOrder(tx *gorm.DB, order *models.Order, status payments.PaymentStatus) error {
+	order.PaymentStatus = string(status)
+	if !canTransitionOrder(order.Status, models.OrderStatusCancelled) {
+		log.Println("Payment lost on order that cannot be cancelled : Order ID = ", order.ID, ", Status = ", order.Status)
+		return savePaymentStatus(tx, order, status)
+	}
+
+	order.CancelReason = "payment " + string(status)
+	if err := transitionOrder(tx, order, models.OrderStatusCancelled, nil, order.CancelReason); err != nil {
+		return err
+	}
+	return restoreStock(tx, order.Lines)
+}
+
+func lockOrderByPaymentIntent(tx *gorm.DB, intentID string) (*models.Order, error) {
+	var id uint
+	if err := tx.Model(&models.Order{}).Where("payment_intent_id = ?", intentID).Pluck("id", &id).Error; err != nil {
+		return nil, err
+	}
+	if id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return lockOrder(tx, id)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"gin-freemarket/utils/payments"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// newTestWebhookService opens the test database with no stored webhook events.
+func newTestWebhookService(t *testing.T) *PaymentWebhookService {
+	db := openTestDB(t,
+		[]interface{}{&models.User{}, &models.Category{}, &models.Tag{}, &models.Item{}, &models.Order{}, &models.OrderLine{}, &models.ProcessedWebhookEvent{}},
+		&models.ProcessedWebhookEvent{})
+	return &PaymentWebhookService{
+		webhookEventRepository: repositories.NewWebhookEventRepository(db),
+		secret:                 testWebhookSecret,
+		maxAttempts:            10,
+		db:                     db,
+	}
+}
+
+// testWebhookPayload is an event of a payment no order knows yet, so it stays pending.
+func testWebhookPayload(eventID string) []byte {
+	return []byte(fmt.Sprintf(`{"id":%q,"type":"payment.captured","payment_intent_id":"pi_unknown"}`, eventID))
+}
+
+func TestPaymentWebhookDeduplication(t *testing.T) {
+	s := newTestWebhookService(t)
+	payload := testWebhookPayload("evt_dedup")
+
+	first, duplicate, err := s.Receive(payload, payments.SignWebhook(payload, testWebhookSecret, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicate {
+		t.Fatal("first delivery reported as duplicate")
+	}
+	if first.Status != models.WebhookEventPending || first.Attempts != 1 {
+		t.Fatalf("event is %s after %d attempts, want pending after 1", first.Status, first.Attempts)
+	}
+
+	// the provider signs every delivery again
+	again, duplicate, err := s.Receive(payload, payments.SignWebhook(payload, testWebhookSecret, time.Now().Add(time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !duplicate {
+		t.Fatal("redelivery not reported as duplicate")
+	}
+	if again.ID != first.ID || again.Attempts != 1 {
+		t.Fatalf("redelivery returned event %d after %d attempts, want %d after 1", again.ID, again.Attempts, first.ID)
+	}
+}
+
+func TestPaymentWebhookConcurrentRedelivery(t *testing.T) {
+	s := newTestWebhookService(t)
+	payload := testWebhookPayload("evt_concurrent")
+	signature := payments.SignWebhook(payload, testWebhookSecret, time.Now())
+
+	const deliveries = 10
+	var wg sync.WaitGroup
+	results := make(chan bool, deliveries)
+	for i := 0; i < deliveries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, duplicate, err := s.Receive(payload, signature)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results <- duplicate
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	fresh := 0
+	for duplicate := range results {
+		if !duplicate {
+			fresh++
+		}
+	}
+	if fresh != 1 {
+		t.Fatalf("%d deliveries were applied, want 1", fresh)
+	}
+	var count int64
+	if err := s.db.Model(&models.ProcessedWebhookEvent{}).Where("event_id = ?", "evt_concurrent").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d rows stored for one event", count)
+	}
+}
+
+func TestPaymentWebhookRejectsBadSignature(t *testing.T) {
+	s := newTestWebhookService(t)
+	payload := testWebhookPayload("evt_forged")
+
+	_, _, err := s.Receive(payload, payments.SignWebhook(payload, "whsec_other", time.Now()))
+	if !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Fatalf("got %v, want ErrInvalidWebhookSignature", err)
+	}
+	if _, err := s.webhookEventRepository.FindByEventID("evt_forged"); err == nil {
+		t.Fatal("forged event was stored")
+	}
+}
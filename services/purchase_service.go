@@ -5,36 +5,46 @@ import (
 	"gin-freemarket/dto"
 	"gin-freemarket/models"
 	"gin-freemarket/repositories"
+	"log"
+	"time"
 
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type IPurchaseService interface {
 	Create(userID uint, input dto.PurchaseItemInput) (purchase *dto.PurchaseResponse, err error)
 	FindAll(userID uint) ([]*dto.PurchaseResponse, error)
 	FindById(userID uint, id uint) (*dto.PurchaseResponse, error)
+	Cancel(userID uint, id uint, input dto.CancelOrderInput) (*dto.PurchaseResponse, error)
 }
 
 type PurchaseService struct {
 	purchaseRepository repositories.IPurchaseRepository
 	itemRepository     repositories.IItemRepository
+	paymentOutbox      IPaymentOutbox
+	pricing            OrderPricing
+	buyerWindow        time.Duration
 	db                 *gorm.DB
 }
 
 func NewPurchaseService(
 	purchaseRepository repositories.IPurchaseRepository,
 	itemRepository repositories.IItemRepository,
+	paymentOutbox IPaymentOutbox,
 	db *gorm.DB,
 ) IPurchaseService {
 	return &PurchaseService{
 		purchaseRepository: purchaseRepository,
 		itemRepository:     itemRepository,
+		paymentOutbox:      paymentOutbox,
+		pricing:            LoadOrderPricing(),
+		buyerWindow:        LoadBuyerCancelWindow(),
 		db:                 db,
 	}
 }
 
 // This implementation handles transactions across multiple tables to ensure consistency between tables, so it's implemented within the Service.
+// A purchase is stored as an order with a single line, the response is that line.
 func (s *PurchaseService) Create(userID uint, input dto.PurchaseItemInput) (purchase *dto.PurchaseResponse, err error) {
 	// Start transaction
 	// Begin gets a new context
@@ -52,64 +62,28 @@ func (s *PurchaseService) Create(userID uint, input dto.PurchaseItemInput) (purc
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
 
-	// Get item with exclusive lock
-	// Postgres / Mysql uses row lock. SQLite uses table lock
-	// SELECT * from items where id = ? for update
-	var item models.Item
-	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, input.ItemID).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("item not found: %w", err)
-	}
-
-	// Check stock
-	if item.Quantity <= input.Quantity {
-		tx.Rollback()
-		return nil, fmt.Errorf("item out of stock")
-	}
-
-	// Reduce stock
-	item.Quantity -= input.Quantity
-	if err := tx.Save(&item).Error; err != nil {
+	// Lock the item, check and reduce stock and create the order
+	order, err := placeOrder(tx, s.pricing, userID, []orderLineRequest{{ItemID: input.ItemID, Quantity: input.Quantity}})
+	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
-	// Create purchase record
-	purchaseModel := &models.Purchase{
-		UserID:     userID,
-		ItemID:     input.ItemID,
-		Price:      int(item.Price),
-		Quantity:   int(input.Quantity),
-		TotalPrice: int(item.Price * uint(input.Quantity)),
+	// Commit if no issues
+	if err = tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	if err := tx.Create(purchaseModel).Error; err != nil {
-		tx.Rollback()
+	// Authorize the payment once no lock is held
+	if err := authorizeOrders(s.db, s.paymentOutbox, []*models.Order{order}); err != nil {
 		return nil, err
 	}
 
-	// Verify data within transaction as a precaution
-	var createdPurchase models.Purchase
-	if err := tx.Preload("User").Preload("Item").Where("id = ? AND user_id = ?", purchaseModel.ID, userID).
-		First(&createdPurchase).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to verify created data: %w", err)
-	}
-
-	// Data validation
-	if createdPurchase.ID != purchaseModel.ID ||
-		createdPurchase.UserID != purchaseModel.UserID ||
-		createdPurchase.ItemID != purchaseModel.ItemID {
-		tx.Rollback()
-		return nil, fmt.Errorf("data validation failed")
-	}
-
-	// Commit if no issues
-	if err = tx.Commit().Error; err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	createdPurchase, err := s.purchaseRepository.FindById(userID, order.Lines[0].ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load created purchase: %w", err)
 	}
-
-	return dto.ToPurchaseResponse(&createdPurchase), nil
+	return dto.ToPurchaseResponse(createdPurchase), nil
 }
 
 func (s *PurchaseService) FindAll(userID uint) ([]*dto.PurchaseResponse, error) {
@@ -134,3 +108,41 @@ func (s *PurchaseService) FindById(userID uint, id uint) (*dto.PurchaseResponse,
 
 	return dto.ToPurchaseResponse(purchase), nil
 }
+
+// Cancel cancels the order the purchase belongs to and puts the stock back.
+// Buyers can cancel within the cancellation window, sellers until the order is shipped.
+func (s *PurchaseService) Cancel(userID uint, id uint, input dto.CancelOrderInput) (*dto.PurchaseResponse, error) {
+	purchase, err := s.purchaseRepository.FindByIdWithoutOwner(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	// Lock the order first, then its items in item ID order
+	order, err := lockOrder(tx, purchase.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := cancelOrder(tx, order, userID, input.Reason, s.buyerWindow); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Println("Cancel purchase success : Purchase ID = ", id, ", Order ID = ", order.ID, ", User ID = ", userID)
+	s.paymentOutbox.ProcessOrder(order.ID)
+
+	cancelled, err := s.purchaseRepository.FindByIdWithoutOwner(id)
+	if err != nil {
+		return nil, err
+	}
+	return dto.ToPurchaseResponse(cancelled), nil
+}
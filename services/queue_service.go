@@ -0,0 +1,29 @@
+package services
+
+import (
+	"gin-freemarket/dto"
+	"gin-freemarket/utils/sessions"
+)
+
+// IQueueService reports the progress of tickets in the session waiting room.
+type IQueueService interface {
+	Status(ticketID string) (*dto.QueueStatusResponse, error)
+}
+
+type QueueService struct {
+	sessionManager sessions.ISessionManager
+}
+
+func NewQueueService(sessionManager sessions.ISessionManager) IQueueService {
+	return &QueueService{sessionManager: sessionManager}
+}
+
+// Status also keeps the ticket alive, clients that stop polling lose their place.
+func (s *QueueService) Status(ticketID string) (*dto.QueueStatusResponse, error) {
+	ticket, err := s.sessionManager.QueueStatus(ticketID)
+	if err != nil {
+		return nil, err
+	}
+	response := dto.ToQueueStatusResponse(ticket)
+	return &response, nil
+}
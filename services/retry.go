@@ -0,0 +1,13 @@
+package services
+
+import "time"
+
+// retryDelay is the wait before the next attempt after attempts failures:
+// base after the first failure, doubling with every further one up to maxDelay.
+func retryDelay(attempts int, base time.Duration, maxDelay time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
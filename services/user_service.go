@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gin-freemarket/dto"
+	"gin-freemarket/models"
+	"gin-freemarket/repositories"
+	"gin-freemarket/utils/loginguard"
+	"gin-freemarket/utils/sessions"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrCannotChangeOwnAccount = errors.New("you cannot change your own role or suspension")
+
+// IUserService holds the admin operations on users.
+// Every change revokes the user's access tokens, so that the role claim is refreshed right away.
+type IUserService interface {
+	UpdateRole(adminID uint, userID uint, input dto.UpdateUserRoleInput) (*dto.UserAccountResponse, error)
+	Suspend(adminID uint, userID uint) (*dto.UserAccountResponse, error)
+	Unsuspend(adminID uint, userID uint) (*dto.UserAccountResponse, error)
+	UnlockLogin(adminID uint, userID uint) (*dto.UserAccountResponse, error)
+}
+
+type UserService struct {
+	authRepository repositories.IAuthRepository
+	sessionManager sessions.ISessionManager
+	loginGuard     *loginguard.Guard
+	db             *gorm.DB
+}
+
+func NewUserService(authRepository repositories.IAuthRepository, sessionManager sessions.ISessionManager, loginGuard *loginguard.Guard, db *gorm.DB) IUserService {
+	return &UserService{authRepository: authRepository, sessionManager: sessionManager, loginGuard: loginGuard, db: db}
+}
+
+func (s *UserService) UpdateRole(adminID uint, userID uint, input dto.UpdateUserRoleInput) (*dto.UserAccountResponse, error) {
+	return s.change(adminID, userID, func(user *models.User) {
+		user.Role = input.Role
+	})
+}
+
+func (s *UserService) Suspend(adminID uint, userID uint) (*dto.UserAccountResponse, error) {
+	return s.change(adminID, userID, func(user *models.User) {
+		if user.SuspendedAt == nil {
+			now := time.Now()
+			user.SuspendedAt = &now
+		}
+	})
+}
+
+func (s *UserService) Unsuspend(adminID uint, userID uint) (*dto.UserAccountResponse, error) {
+	return s.change(adminID, userID, func(user *models.User) {
+		user.SuspendedAt = nil
+	})
+}
+
+// UnlockLogin lifts the lockout after failed logins to the user's account.
+// Blocks of the client IPs are left alone, they expire on their own.
+func (s *UserService) UnlockLogin(adminID uint, userID uint) (*dto.UserAccountResponse, error) {
+	user, err := s.authRepository.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loginGuard.Unlock(context.Background(), user.Email); err != nil {
+		return nil, err
+	}
+	log.Println("Login unlocked by admin : User ID = ", user.ID, ", Admin ID = ", adminID)
+	return dto.ToUserAccountResponse(user), nil
+}
+
+func (s *UserService) change(adminID uint, userID uint, apply func(user *models.User)) (*dto.UserAccountResponse, error) {
+	// an admin locking themselves out would leave nobody to undo it
+	if adminID == userID {
+		return nil, ErrCannotChangeOwnAccount
+	}
+
+	user, err := s.authRepository.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	apply(user)
+	if err := s.db.Model(user).Select("Role", "SuspendedAt").Updates(user).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionManager.RevokeUserTokens(user.ID, LoadAccessTokenTTL()); err != nil {
+		return nil, err
+	}
+	log.Println("User changed by admin : User ID = ", user.ID, ", Admin ID = ", adminID, ", Role = ", user.Role, ", Suspended = ", user.SuspendedAt != nil)
+	return dto.ToUserAccountResponse(user), nil
+}
@@ -0,0 +1,31 @@
+// Package env reads settings from environment variables, falling back to a default when a variable is unset or invalid.
+package env
+
+import (
+	"os"
+	"strconv"
+)
+
+func String(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func Int(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// IntAtLeast is Int for settings with a lower bound, a smaller value falls back to the default as well.
+func IntAtLeast(key string, minValue int, defaultValue int) int {
+	value := Int(key, defaultValue)
+	if value < minValue {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,118 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"gin-freemarket/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps keys in the idempotency_keys table, for deployments without Redis.
+// Expired rows are taken over by the next request using the key and purged periodically.
+type PostgresStore struct {
+	db      *gorm.DB
+	lockTTL time.Duration
+}
+
+func NewPostgresStore(db *gorm.DB, lockTTL time.Duration) *PostgresStore {
+	s := &PostgresStore{db: db, lockTTL: lockTTL}
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := db.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{}).Error; err != nil {
+				log.Printf("Error purging expired idempotency keys: %v", err)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *PostgresStore) Begin(ctx context.Context, key string, fingerprint string) (*Record, bool, error) {
+	owner, err := newOwner()
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	row := models.IdempotencyKey{
+		Key:         key,
+		Owner:       owner,
+		Fingerprint: fingerprint,
+		State:       string(StateInFlight),
+		ExpiresAt:   now.Add(s.lockTTL),
+	}
+
+	db := s.db.WithContext(ctx)
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return &Record{State: StateInFlight, Owner: owner, Fingerprint: fingerprint}, true, nil
+	}
+
+	// the key exists, take it over if it expired
+	result = db.Model(&models.IdempotencyKey{}).
+		Where("key = ? AND expires_at < ?", key, now).
+		Updates(map[string]interface{}{
+			"owner":        owner,
+			"fingerprint":  fingerprint,
+			"state":        string(StateInFlight),
+			"status_code":  0,
+			"content_type": "",
+			"body":         nil,
+			"expires_at":   now.Add(s.lockTTL),
+		})
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return &Record{State: StateInFlight, Owner: owner, Fingerprint: fingerprint}, true, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := db.Where("key = ?", key).First(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// purged in the meantime
+			return s.Begin(ctx, key, fingerprint)
+		}
+		return nil, false, err
+	}
+	return &Record{
+		State:       State(existing.State),
+		Fingerprint: existing.Fingerprint,
+		StatusCode:  existing.StatusCode,
+		ContentType: existing.ContentType,
+		Body:        existing.Body,
+	}, false, nil
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, key string, owner string, record Record, ttl time.Duration) error {
+	result := s.db.WithContext(ctx).Model(&models.IdempotencyKey{}).
+		Where("key = ? AND owner = ? AND state = ? AND expires_at >= ?", key, owner, StateInFlight, time.Now()).
+		Updates(map[string]interface{}{
+			"state":        string(StateCompleted),
+			"status_code":  record.StatusCode,
+			"content_type": record.ContentType,
+			"body":         record.Body,
+			"expires_at":   time.Now().Add(ttl),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrKeyNotHeld
+	}
+	return nil
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string, owner string) error {
+	return s.db.WithContext(ctx).Where("key = ? AND owner = ? AND state = ?", key, owner, StateInFlight).Delete(&models.IdempotencyKey{}).Error
+}
@@ -0,0 +1,112 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "idempotency:"
+
+// RedisStore keeps each key as a JSON string. SETNX claims the key with the lock TTL,
+// completing the request overwrites it with the response and the full TTL.
+// Completing and releasing compare the owner in a script, so a request whose lock expired
+// cannot touch the key of a newer request.
+type RedisStore struct {
+	redis   *redis.Client
+	lockTTL time.Duration
+}
+
+func NewRedisStore(client *redis.Client, lockTTL time.Duration) *RedisStore {
+	return &RedisStore{redis: client, lockTTL: lockTTL}
+}
+
+func (s *RedisStore) Begin(ctx context.Context, key string, fingerprint string) (*Record, bool, error) {
+	owner, err := newOwner()
+	if err != nil {
+		return nil, false, err
+	}
+	record := &Record{State: StateInFlight, Owner: owner, Fingerprint: fingerprint}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok, err := s.redis.SetNX(ctx, redisKeyPrefix+key, value, s.lockTTL).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		return record, true, nil
+	}
+
+	existing, err := s.redis.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err == redis.Nil {
+		// expired between SETNX and GET
+		return s.Begin(ctx, key, fingerprint)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var stored Record
+	if err := json.Unmarshal(existing, &stored); err != nil {
+		return nil, false, err
+	}
+	return &stored, false, nil
+}
+
+func (s *RedisStore) Complete(ctx context.Context, key string, owner string, record Record, ttl time.Duration) error {
+	record.State = StateCompleted
+	record.Owner = owner
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// a key whose lock already expired is not brought back
+	ok, err := completeIfOwner.Run(ctx, s.redis, []string{redisKeyPrefix + key}, owner, value, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrKeyNotHeld
+	}
+	return nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string, owner string) error {
+	return releaseIfOwner.Run(ctx, s.redis, []string{redisKeyPrefix + key}, owner).Err()
+}
+
+// inFlightOwner returns the owner of the in-flight record at KEYS[1], false for other records.
+const inFlightOwner = `
+local function in_flight_owner(key)
+	local value = redis.call('GET', key)
+	if not value then
+		return false
+	end
+	local record = cjson.decode(value)
+	if record.state ~= 'in_flight' then
+		return false
+	end
+	return record.owner
+end
+`
+
+// completeIfOwner stores the response ARGV[2] for ARGV[3] ms when ARGV[1] holds the key. Returns 1 when stored.
+var completeIfOwner = redis.NewScript(inFlightOwner + `
+if in_flight_owner(KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// releaseIfOwner deletes the key when ARGV[1] holds it.
+var releaseIfOwner = redis.NewScript(inFlightOwner + `
+if in_flight_owner(KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
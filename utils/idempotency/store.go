@@ -0,0 +1,83 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+type State string
+
+const (
+	StateInFlight  State = "in_flight"
+	StateCompleted State = "completed"
+)
+
+// DefaultLockTTL bounds how long a request may hold its key while running.
+// A request that dies without releasing its key blocks retries only this long.
+const DefaultLockTTL = time.Minute
+
+var ErrKeyNotHeld = errors.New("idempotency key is not held")
+
+// Record is what is stored under an idempotency key.
+type Record struct {
+	State State `json:"state"`
+	// Owner is a random token of the request holding the key, only that request may complete or release it
+	Owner string `json:"owner,omitempty"`
+	// Fingerprint identifies the request the key was first used with
+	Fingerprint string `json:"fingerprint"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Store keeps idempotency keys and the responses stored for them.
+type Store interface {
+	// Begin claims the key for a new request, the returned record carries the owner token of the request.
+	// When the key already exists nothing is changed, started is false and the existing record is returned.
+	Begin(ctx context.Context, key string, fingerprint string) (record *Record, started bool, err error)
+	// Complete stores the response of the request holding the key as owner, it is kept for ttl.
+	// ErrKeyNotHeld is returned when the lock expired and the key is gone or taken by another request.
+	Complete(ctx context.Context, key string, owner string, record Record, ttl time.Duration) error
+	// Release gives up the key without a response, so that the request can be retried.
+	// A key no longer held by owner is left alone.
+	Release(ctx context.Context, key string, owner string) error
+}
+
+func newOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewStoreFromEnv creates the store selected by IDEMPOTENCY_STORE: "redis", "postgres",
+// or by default Redis at REDIS_HOST when it answers and the idempotency_keys table otherwise.
+func NewStoreFromEnv(db *gorm.DB) (Store, error) {
+	switch os.Getenv("IDEMPOTENCY_STORE") {
+	case "redis":
+		return NewRedisStore(redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_HOST")}), DefaultLockTTL), nil
+	case "postgres":
+		return NewPostgresStore(db, DefaultLockTTL), nil
+	case "":
+		client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_HOST")})
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			log.Println("Redis is not available for idempotency keys, using postgres : Error = ", err)
+			client.Close()
+			return NewPostgresStore(db, DefaultLockTTL), nil
+		}
+		return NewRedisStore(client, DefaultLockTTL), nil
+	default:
+		return nil, errors.New("unknown IDEMPOTENCY_STORE: " + os.Getenv("IDEMPOTENCY_STORE"))
+	}
+}
@@ -0,0 +1,91 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// register decoders used by image.Decode
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ErrTooManyPixels is returned by Decode for images larger than the pixel cap.
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+// Decode decodes a JPEG, PNG or GIF image of at most maxPixels pixels.
+// The dimensions are read from the header first, so that a small file declaring a huge image
+// is rejected before its pixels are allocated.
+func Decode(data []byte, maxPixels int) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxPixels/config.Height {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Thumbnail scales the image down to fit in a maxSize x maxSize box keeping the aspect ratio
+// and encodes it as JPEG. Images already smaller than the box are only re-encoded.
+func Thumbnail(img image.Image, maxSize int) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			height = max(1, height*maxSize/width)
+			width = maxSize
+		} else {
+			width = max(1, width*maxSize/height)
+			height = maxSize
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resize(img, width, height), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales with a box filter: every target pixel is the average of the source pixels it covers.
+func resize(src image.Image, width int, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcHeight/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcWidth/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			// JPEG has no alpha channel, so transparent pixels are put on a white background
+			background := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r/n + background),
+				G: uint16(g/n + background),
+				B: uint16(b/n + background),
+				A: 0xffff,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,184 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key is one key of the set. Private is nil for retired keys, which only verify tokens issued before the rotation.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeySet signs tokens with the active key and verifies tokens signed by any key it holds, chosen by the kid header.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	// hmacSecret is the legacy JWT_SECRET. Next to key files, tokens signed with it are accepted until hmacUntil.
+	hmacSecret []byte
+	hmacUntil  time.Time
+}
+
+// LoadKeySetFromEnv loads the PEM files in JWT_KEYS_DIR. The file name without ".pem" is the kid:
+// "<kid>.pem" holds a private key, "<kid>.pub.pem" a public key of a retired key.
+// JWT_SIGNING_KEY_ID must name the key that signs new tokens, so that a key added for the next rotation
+// is only published until the setting is changed.
+//
+// Without JWT_KEYS_DIR tokens are signed with HS256 and JWT_SECRET as before.
+// With both set, HS256 tokens issued before the switch keep verifying only when JWT_LEGACY_HS256_UNTIL
+// sets the date they are rejected from, e.g. 2025-01-31 or an RFC 3339 time.
+func LoadKeySetFromEnv() (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*Key)}
+	secret := os.Getenv("JWT_SECRET")
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if secret == "" {
+			return nil, errors.New("either JWT_KEYS_DIR or JWT_SECRET must be set")
+		}
+		set.hmacSecret = []byte(secret)
+		return set, nil
+	}
+
+	if secret != "" {
+		until, err := parseDeadline(os.Getenv("JWT_LEGACY_HS256_UNTIL"))
+		if err != nil {
+			return nil, fmt.Errorf("JWT_LEGACY_HS256_UNTIL: %w", err)
+		}
+		if until.IsZero() {
+			log.Println("JWT_SECRET is ignored, set JWT_LEGACY_HS256_UNTIL to accept HS256 tokens for a while")
+		} else if time.Now().Before(until) {
+			set.hmacSecret = []byte(secret)
+			set.hmacUntil = until
+			log.Println("Legacy HS256 tokens are accepted until ", until.Format(time.RFC3339))
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".pem")
+		kid := strings.TrimSuffix(name, ".pub")
+
+		var key *Key
+		if kid != name {
+			key, err = ParsePublicKeyPEM(kid, data)
+		} else {
+			key, err = ParsePrivateKeyPEM(kid, data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, exists := set.keys[kid]; exists {
+			return nil, fmt.Errorf("duplicate key id %s in %s", kid, dir)
+		}
+		set.keys[kid] = key
+	}
+
+	signingID := os.Getenv("JWT_SIGNING_KEY_ID")
+	if signingID == "" {
+		return nil, errors.New("JWT_SIGNING_KEY_ID must be set with JWT_KEYS_DIR")
+	}
+	signing, ok := set.keys[signingID]
+	if !ok || signing.Private == nil {
+		return nil, fmt.Errorf("no private key %q in %s", signingID, dir)
+	}
+	set.signing = signing
+	log.Println("JWT signing key : ", signing.ID, ", Algorithm = ", signing.Method.Alg(), ", Keys = ", len(set.keys))
+	return set, nil
+}
+
+// Sign signs the claims with the active key and puts its kid in the header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.hmacSecret)
+	}
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.Private)
+}
+
+// Keyfunc returns the verification key for jwt.Parse. The algorithm must match the key,
+// so that a public key can never be used as an HMAC secret.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if s.hmacSecret == nil || (!s.hmacUntil.IsZero() && !time.Now().Before(s.hmacUntil)) {
+			return nil, fmt.Errorf("unexpected method: %s", token.Header["alg"])
+		}
+		return s.hmacSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected method: %s for key %s", token.Header["alg"], kid)
+	}
+	return key.Public, nil
+}
+
+// ValidMethods lists the algorithms the set can verify, for jwt.WithValidMethods.
+func (s *KeySet) ValidMethods() []string {
+	var methods []string
+	if s.hmacSecret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	seen := make(map[string]bool)
+	for _, key := range s.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// parseDeadline reads a date or an RFC 3339 time, zero when value is empty.
+func parseDeadline(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if deadline, err := time.Parse(time.DateOnly, value); err == nil {
+		return deadline, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func newKey(kid string, private crypto.Signer, public crypto.PublicKey) (*Key, error) {
+	key := &Key{ID: kid, Private: private, Public: public}
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", public)
+	}
+	return key, nil
+}
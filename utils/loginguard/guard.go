@@ -0,0 +1,142 @@
+// Package loginguard slows down password guessing. Failed logins are counted in Redis per account and
+// per client IP; past a few free attempts each failure blocks further attempts for an exponentially growing
+// delay, and too many failures lock the account or IP out for a while.
+package loginguard
+
+import (
+	"context"
+	"gin-freemarket/utils/env"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	failurePrefix = "login_failures:"
+	blockPrefix   = "login_blocked:"
+)
+
+// countFailure increments the failure counter, which lives for the window counted from the first failure.
+var countFailure = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+type Config struct {
+	// FreeAttempts failures are allowed without any delay
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure past the free attempts, it doubles with each further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAccountFailures and MaxIPFailures failures within Window lock out for LockoutDuration
+	MaxAccountFailures int
+	MaxIPFailures      int
+	Window             time.Duration
+	LockoutDuration    time.Duration
+}
+
+// LoadConfigFromEnv reads LOGIN_FREE_ATTEMPTS (3), LOGIN_BACKOFF_BASE_SECONDS (1), LOGIN_BACKOFF_MAX_SECONDS (60),
+// LOGIN_MAX_ACCOUNT_FAILURES (10), LOGIN_MAX_IP_FAILURES (50), LOGIN_FAILURE_WINDOW_MINUTES (15)
+// and LOGIN_LOCKOUT_MINUTES (15).
+func LoadConfigFromEnv() Config {
+	return Config{
+		FreeAttempts:       env.Int("LOGIN_FREE_ATTEMPTS", 3),
+		BaseDelay:          time.Duration(env.Int("LOGIN_BACKOFF_BASE_SECONDS", 1)) * time.Second,
+		MaxDelay:           time.Duration(env.Int("LOGIN_BACKOFF_MAX_SECONDS", 60)) * time.Second,
+		MaxAccountFailures: env.Int("LOGIN_MAX_ACCOUNT_FAILURES", 10),
+		MaxIPFailures:      env.Int("LOGIN_MAX_IP_FAILURES", 50),
+		Window:             time.Duration(env.Int("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+		LockoutDuration:    time.Duration(env.Int("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+	}
+}
+
+type Guard struct {
+	redis  *redis.Client
+	config Config
+}
+
+func NewGuard(client *redis.Client, config Config) *Guard {
+	return &Guard{redis: client, config: config}
+}
+
+// NewGuardFromEnv connects to the Redis at REDIS_HOST.
+func NewGuardFromEnv() *Guard {
+	return NewGuard(redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_HOST")}), LoadConfigFromEnv())
+}
+
+// Check returns how long the account or the IP is still blocked, 0 when a login may be attempted.
+// Accounts are counted by the email given at login, whether or not a user has it,
+// so the lockout does not reveal which addresses have accounts.
+func (g *Guard) Check(ctx context.Context, email string, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, key := range []string{blockPrefix + accountKey(email), blockPrefix + ipKey(ip)} {
+		ttl, err := g.redis.PTTL(ctx, key).Result()
+		if err != nil {
+			return 0, err
+		}
+		// a missing key has a negative TTL
+		retryAfter = max(retryAfter, ttl)
+	}
+	return retryAfter, nil
+}
+
+// RecordFailure counts a failed login and blocks further attempts as configured.
+func (g *Guard) RecordFailure(ctx context.Context, email string, ip string) error {
+	if err := g.recordFailure(ctx, accountKey(email), g.config.MaxAccountFailures); err != nil {
+		return err
+	}
+	return g.recordFailure(ctx, ipKey(ip), g.config.MaxIPFailures)
+}
+
+// RecordSuccess clears the failures of the account. The IP keeps its count, a successful login
+// to one account says nothing about the guesses made against others.
+func (g *Guard) RecordSuccess(ctx context.Context, email string) error {
+	return g.Unlock(ctx, email)
+}
+
+// Unlock lifts the lockout of an account and forgets its failures.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	key := accountKey(email)
+	return g.redis.Del(ctx, failurePrefix+key, blockPrefix+key).Err()
+}
+
+func (g *Guard) recordFailure(ctx context.Context, key string, maxFailures int) error {
+	failures, err := countFailure.Run(ctx, g.redis, []string{failurePrefix + key}, g.config.Window.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+
+	delay := g.delayAfter(failures, maxFailures)
+	if delay <= 0 {
+		return nil
+	}
+	return g.redis.Set(ctx, blockPrefix+key, failures, delay).Err()
+}
+
+// delayAfter is the time attempts are blocked after the given number of failures.
+func (g *Guard) delayAfter(failures int, maxFailures int) time.Duration {
+	if maxFailures > 0 && failures >= maxFailures {
+		return g.config.LockoutDuration
+	}
+	if failures <= g.config.FreeAttempts {
+		return 0
+	}
+	delay := g.config.BaseDelay
+	for i := g.config.FreeAttempts + 1; i < failures && delay < g.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, g.config.MaxDelay)
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LogMailer writes messages to the application log instead of sending them, for local development.
+// Tokens in links are redacted, logs are kept and shipped where the tokens must not end up.
+type LogMailer struct {
+	from string
+}
+
+var linkTokens = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	log.Printf("Mail to %s\n%s", message.To, linkTokens.ReplaceAll(formatMessage(m.from, message), []byte("${1}[redacted]")))
+	return nil
+}
+
+// FileMailer writes every message as an .eml file, which mail clients can open.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b) + ".eml"
+	return os.WriteFile(filepath.Join(m.dir, name), formatMessage(m.from, message), 0o644)
+}
+
+// formatMessage renders the message in RFC 5322 format.
+func formatMessage(from string, message Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"gin-freemarket/utils/env"
+	"os"
+	"strconv"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends transactional emails such as verification and password reset links.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewMailerFromEnv creates the mailer selected by MAILER ("log" by default, "file" or "smtp").
+//
+// log  : messages are written to the application log, with the tokens in links redacted
+// file : messages are written as .eml files to MAIL_DIR (default ./tmp/mail)
+// smtp : SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM
+func NewMailerFromEnv() (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return NewLogMailer(env.String("MAIL_FROM", "no-reply@localhost")), nil
+	case "file":
+		return NewFileMailer(env.String("MAIL_DIR", "./tmp/mail"), env.String("MAIL_FROM", "no-reply@localhost"))
+	case "smtp":
+		port, err := strconv.Atoi(env.String("SMTP_PORT", "587"))
+		if err != nil {
+			return nil, errors.New("invalid SMTP_PORT: " + os.Getenv("SMTP_PORT"))
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		})
+	default:
+		return nil, errors.New("unknown MAILER: " + os.Getenv("MAILER"))
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+type SMTPConfig struct {
+	Host string
+	Port int
+	// Username and Password are optional, MailHog and similar stand-ins accept mail without auth
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer delivers through an SMTP server. STARTTLS is used when the server offers it.
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	if config.Host == "" || config.From == "" {
+		return nil, errors.New("SMTP_HOST and MAIL_FROM are required")
+	}
+	return &SMTPMailer{config: config}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	if err := smtp.SendMail(addr, auth, m.config.From, []string{message.To}, formatMessage(m.config.From, message)); err != nil {
+		return fmt.Errorf("send mail to %s: %w", message.To, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package passwords hashes and checks user passwords.
+//
+// Hashes are stored in a self-describing format, so that several algorithms can coexist:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>   current, salt and hash in unpadded base64
+//	$2a$10$...                                     legacy bcrypt of password + global salt
+//
+// Verify reports when a hash is not in the current format or parameters, the caller then stores a new hash.
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gin-freemarket/utils/env"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// Argon2Params are the cost parameters of new hashes.
+type Argon2Params struct {
+	// MemoryKiB is the memory used by one hash in KiB
+	MemoryKiB   uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// LoadArgon2ParamsFromEnv reads PASSWORD_ARGON2_MEMORY_KIB (65536), PASSWORD_ARGON2_ITERATIONS (3)
+// and PASSWORD_ARGON2_PARALLELISM (2). Changing them re-hashes passwords as users log in.
+func LoadArgon2ParamsFromEnv() Argon2Params {
+	return Argon2Params{
+		MemoryKiB:   uint32(env.Int("PASSWORD_ARGON2_MEMORY_KIB", 64*1024)),
+		Iterations:  uint32(env.Int("PASSWORD_ARGON2_ITERATIONS", 3)),
+		Parallelism: uint8(env.Int("PASSWORD_ARGON2_PARALLELISM", 2)),
+	}
+}
+
+type Hasher struct {
+	params Argon2Params
+	// legacySalt was appended to every password before bcrypt, it is only needed to check old hashes
+	legacySalt string
+}
+
+func NewHasher(params Argon2Params, legacySalt string) *Hasher {
+	return &Hasher{params: params, legacySalt: legacySalt}
+}
+
+// Hash returns the argon2id hash of the password with a random salt.
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.MemoryKiB, h.params.Parallelism, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.MemoryKiB, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against the stored hash. needsRehash is set for a matching password
+// whose hash uses a legacy algorithm or other parameters than the current ones.
+func (h *Hasher) Verify(password string, encoded string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return h.verifyArgon2(password, encoded)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password+h.legacySalt))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	default:
+		return false, false, ErrUnknownHashFormat
+	}
+}
+
+func (h *Hasher) verifyArgon2(password string, encoded string) (bool, bool, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrUnknownHashFormat
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.MemoryKiB, &params.Iterations, &params.Parallelism); err != nil {
+		return false, false, ErrUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, false, ErrUnknownHashFormat
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.MemoryKiB, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+	return true, params != h.params || len(salt) != saltLength || len(key) != keyLength, nil
+}
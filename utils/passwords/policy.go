@@ -0,0 +1,100 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gin-freemarket/utils/env"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordBreached = errors.New("password appears in a list of breached passwords, choose another one")
+)
+
+// Policy is checked when a password is chosen, at registration and reset. Existing passwords are not affected.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// breached holds upper-case SHA-1 hex digests of known breached passwords
+	breached map[string]struct{}
+}
+
+// LoadPolicyFromEnv reads PASSWORD_MIN_LENGTH (8), PASSWORD_MAX_LENGTH (128)
+// and the optional breached password list PASSWORD_BREACHED_LIST, see LoadBreachedList.
+func LoadPolicyFromEnv() (*Policy, error) {
+	policy := &Policy{
+		MinLength: env.IntAtLeast("PASSWORD_MIN_LENGTH", 1, 8),
+		MaxLength: env.IntAtLeast("PASSWORD_MAX_LENGTH", 1, 128),
+	}
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		breached, err := LoadBreachedList(path)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = breached
+	}
+	return policy, nil
+}
+
+// LoadBreachedList reads a file with one entry per line: either a plain password, or the SHA-1 digest of one
+// in hex as in the Pwned Passwords downloads, where a ":count" suffix is ignored.
+func LoadBreachedList(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); isSHA1Hex(digest) {
+			breached[strings.ToUpper(digest)] = struct{}{}
+			continue
+		}
+		breached[sha1Hex(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breached password list: %w", err)
+	}
+	return breached, nil
+}
+
+// Check returns the first rule the password breaks, nil when it is acceptable.
+func (p *Policy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters", ErrPasswordTooShort, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w: at most %d characters", ErrPasswordTooLong, p.MaxLength)
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+func sha1Hex(value string) string {
+	sum := sha1.Sum([]byte(value))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(value string) bool {
+	if len(value) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
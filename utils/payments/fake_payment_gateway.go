@@ -0,0 +1,125 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// FakePaymentGateway is an in-process provider for local development and tests.
+// It keeps intents in memory and enforces the same state rules as a real provider.
+type FakePaymentGateway struct {
+	mu      sync.Mutex
+	intents map[string]*PaymentIntent
+	// applied maps idempotency keys of applied operations to their intent
+	applied map[string]string
+	// DeclineAbove declines authorizations of larger amounts when positive
+	DeclineAbove int
+}
+
+func NewFakePaymentGateway() *FakePaymentGateway {
+	return &FakePaymentGateway{
+		intents: make(map[string]*PaymentIntent),
+		applied: make(map[string]string),
+	}
+}
+
+func (g *FakePaymentGateway) Authorize(ctx context.Context, request AuthorizeRequest) (*PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// same key, same intent: a retried authorization does not hold the funds twice
+	if id, ok := g.applied[request.IdempotencyKey]; ok && request.IdempotencyKey != "" {
+		return g.copyOf(id), nil
+	}
+	if request.Amount < 0 || (g.DeclineAbove > 0 && request.Amount > g.DeclineAbove) {
+		return nil, fmt.Errorf("%w: amount %d", ErrPaymentDeclined, request.Amount)
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	intent := &PaymentIntent{
+		ID:       "pi_fake_" + hex.EncodeToString(b),
+		Status:   PaymentStatusAuthorized,
+		Amount:   request.Amount,
+		Currency: request.Currency,
+	}
+	g.intents[intent.ID] = intent
+	if request.IdempotencyKey != "" {
+		g.applied[request.IdempotencyKey] = intent.ID
+	}
+	return g.copyOf(intent.ID), nil
+}
+
+func (g *FakePaymentGateway) Capture(ctx context.Context, intentID string, idempotencyKey string) (*PaymentIntent, error) {
+	return g.update(intentID, idempotencyKey, func(intent *PaymentIntent) error {
+		if intent.Status != PaymentStatusAuthorized {
+			return ErrInvalidIntentState
+		}
+		intent.Status = PaymentStatusCaptured
+		return nil
+	})
+}
+
+func (g *FakePaymentGateway) Refund(ctx context.Context, intentID string, amount int, idempotencyKey string) (*PaymentIntent, error) {
+	return g.update(intentID, idempotencyKey, func(intent *PaymentIntent) error {
+		if intent.Status != PaymentStatusCaptured {
+			return ErrInvalidIntentState
+		}
+		remaining := intent.Amount - intent.Refunded
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount < 0 || amount > remaining {
+			return fmt.Errorf("%w: refund %d exceeds remaining %d", ErrInvalidIntentState, amount, remaining)
+		}
+		intent.Refunded += amount
+		if intent.Refunded == intent.Amount {
+			intent.Status = PaymentStatusRefunded
+		}
+		return nil
+	})
+}
+
+func (g *FakePaymentGateway) Void(ctx context.Context, intentID string, idempotencyKey string) (*PaymentIntent, error) {
+	return g.update(intentID, idempotencyKey, func(intent *PaymentIntent) error {
+		if intent.Status != PaymentStatusAuthorized {
+			return ErrInvalidIntentState
+		}
+		intent.Status = PaymentStatusVoided
+		return nil
+	})
+}
+
+func (g *FakePaymentGateway) update(intentID string, idempotencyKey string, change func(intent *PaymentIntent) error) (*PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	// a repeated operation is not applied again
+	if id, ok := g.applied[idempotencyKey]; ok && idempotencyKey != "" {
+		if id != intentID {
+			return nil, fmt.Errorf("%w: idempotency key used for another intent", ErrInvalidIntentState)
+		}
+		return g.copyOf(intentID), nil
+	}
+	if err := change(intent); err != nil {
+		return nil, err
+	}
+	if idempotencyKey != "" {
+		g.applied[idempotencyKey] = intentID
+	}
+	return g.copyOf(intentID), nil
+}
+
+func (g *FakePaymentGateway) copyOf(intentID string) *PaymentIntent {
+	intent := *g.intents[intentID]
+	return &intent
+}
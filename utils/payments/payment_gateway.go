@@ -0,0 +1,73 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentStatusAuthorized PaymentStatus = "authorized"
+	PaymentStatusCaptured   PaymentStatus = "captured"
+	PaymentStatusRefunded   PaymentStatus = "refunded"
+	PaymentStatusVoided     PaymentStatus = "voided"
+	PaymentStatusFailed     PaymentStatus = "failed"
+)
+
+var (
+	ErrPaymentDeclined    = errors.New("payment declined")
+	ErrIntentNotFound     = errors.New("payment intent not found")
+	ErrInvalidIntentState = errors.New("operation not allowed in the current payment state")
+)
+
+// PaymentIntent is the provider side state of one payment.
+type PaymentIntent struct {
+	ID       string
+	Status   PaymentStatus
+	Amount   int
+	Currency string
+	// Refunded is the amount refunded so far
+	Refunded int
+}
+
+type AuthorizeRequest struct {
+	Amount   int
+	Currency string
+	// Reference identifies the order at the provider
+	Reference string
+	// IdempotencyKey makes a repeated authorization return the first intent instead of holding the funds twice
+	IdempotencyKey string
+}
+
+// PaymentGateway moves money through a payment provider.
+// The marketplace runs an escrow: funds are authorized (held) at checkout and captured only when the buyer
+// confirms delivery. A cancelled order is voided while only authorized, and refunded once captured.
+type PaymentGateway interface {
+	// Authorize, Capture, Refund and Void take an idempotency key: a call repeated with the same key is applied once
+	// and returns the intent again, so that an operation can be retried when its outcome was lost.
+	Authorize(ctx context.Context, request AuthorizeRequest) (*PaymentIntent, error)
+	Capture(ctx context.Context, intentID string, idempotencyKey string) (*PaymentIntent, error)
+	// Refund refunds amount of a captured payment, 0 refunds the remaining amount
+	Refund(ctx context.Context, intentID string, amount int, idempotencyKey string) (*PaymentIntent, error)
+	Void(ctx context.Context, intentID string, idempotencyKey string) (*PaymentIntent, error)
+}
+
+// NewPaymentGatewayFromEnv creates the gateway selected by PAYMENT_PROVIDER. Only "fake" (the default) is built in.
+func NewPaymentGatewayFromEnv() (PaymentGateway, error) {
+	switch os.Getenv("PAYMENT_PROVIDER") {
+	case "", "fake":
+		return NewFakePaymentGateway(), nil
+	default:
+		return nil, errors.New("unknown PAYMENT_PROVIDER: " + os.Getenv("PAYMENT_PROVIDER"))
+	}
+}
+
+// Currency returns PAYMENT_CURRENCY, JPY by default.
+func Currency() string {
+	if currency := os.Getenv("PAYMENT_CURRENCY"); currency != "" {
+		return currency
+	}
+	return "JPY"
+}
@@ -0,0 +1,108 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+const WebhookSignatureHeader = "X-Payment-Signature"
+
+// DefaultWebhookTolerance is how old a signed timestamp may be, so that captured requests cannot be replayed later.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+type WebhookEventType string
+
+const (
+	WebhookEventPaymentAuthorized WebhookEventType = "payment.authorized"
+	WebhookEventPaymentCaptured   WebhookEventType = "payment.captured"
+	WebhookEventPaymentFailed     WebhookEventType = "payment.failed"
+	WebhookEventPaymentRefunded   WebhookEventType = "payment.refunded"
+	WebhookEventPaymentVoided     WebhookEventType = "payment.voided"
+)
+
+var (
+	ErrInvalidSignature    = errors.New("invalid webhook signature")
+	ErrInvalidWebhookEvent = errors.New("invalid webhook event")
+)
+
+// WebhookEvent is a notification sent by the payment provider.
+// The provider may deliver an event more than once and in any order.
+type WebhookEvent struct {
+	ID              string           `json:"id"`
+	Type            WebhookEventType `json:"type"`
+	PaymentIntentID string           `json:"payment_intent_id"`
+	Amount          int              `json:"amount"`
+	CreatedAt       time.Time        `json:"created_at"`
+}
+
+// ParseWebhookEvent decodes the request body of a webhook.
+func ParseWebhookEvent(payload []byte) (*WebhookEvent, error) {
+	var event WebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, errors.Join(ErrInvalidWebhookEvent, err)
+	}
+	if event.ID == "" || event.Type == "" {
+		return nil, ErrInvalidWebhookEvent
+	}
+	return &event, nil
+}
+
+// SignWebhook returns the signature header value for the payload, as the provider computes it.
+func SignWebhook(payload []byte, secret string, timestamp time.Time) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + computeWebhookSignature(payload, secret, t)
+}
+
+// VerifyWebhookSignature checks the signature header of a webhook request.
+// Any of several v1 values may match, providers send one per active secret while rotating.
+func VerifyWebhookSignature(payload []byte, header string, secret string, tolerance time.Duration, now time.Time) error {
+	if secret == "" || header == "" {
+		return ErrInvalidSignature
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := computeWebhookSignature(payload, secret, timestamp)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func computeWebhookSignature(payload []byte, secret string, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
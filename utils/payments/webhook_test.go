@@ -0,0 +1,54 @@
+package payments
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	const secret = "whsec_test"
+	payload := []byte(`{"id":"evt_1","type":"payment.captured","payment_intent_id":"pi_1"}`)
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	v1 := computeWebhookSignature(payload, secret, timestamp)
+	signed := "t=" + timestamp + ",v1=" + v1
+
+	cases := []struct {
+		name    string
+		payload []byte
+		header  string
+		secret  string
+		now     time.Time
+		valid   bool
+	}{
+		{"valid", payload, signed, secret, now, true},
+		{"signed by SignWebhook", payload, SignWebhook(payload, secret, now), secret, now, true},
+		{"timestamp within the tolerance", payload, signed, secret, now.Add(DefaultWebhookTolerance), true},
+		{"one of several signatures matches", payload, "t=" + timestamp + ",v1=00ff,v1=" + v1, secret, now, true},
+		{"tampered body", []byte(`{"id":"evt_1","type":"payment.refunded","payment_intent_id":"pi_1"}`), signed, secret, now, false},
+		{"wrong secret", payload, SignWebhook(payload, "whsec_other", now), secret, now, false},
+		{"stale timestamp", payload, signed, secret, now.Add(DefaultWebhookTolerance + time.Second), false},
+		{"timestamp from the future", payload, signed, secret, now.Add(-DefaultWebhookTolerance - time.Second), false},
+		{"timestamp changed after signing", payload, "t=" + strconv.FormatInt(now.Unix()+1, 10) + ",v1=" + v1, secret, now, false},
+		{"empty header", payload, "", secret, now, false},
+		{"empty secret", payload, signed, "", now, false},
+		{"header without signature", payload, "t=" + timestamp, secret, now, false},
+		{"header without timestamp", payload, "v1=" + v1, secret, now, false},
+		{"malformed timestamp", payload, "t=yesterday,v1=" + v1, secret, now, false},
+		{"malformed header", payload, "garbage", secret, now, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyWebhookSignature(tc.payload, tc.header, tc.secret, DefaultWebhookTolerance, tc.now)
+			if tc.valid && err != nil {
+				t.Fatalf("signature rejected: %v", err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("got %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package sessions
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemorySessionManager keeps the sessions in the process, for a single instance of the app and local development.
+// Everything is lost on restart, users then simply get a new session.
+type MemorySessionManager struct {
+	mu         sync.Mutex
+	limit      int
+	maxPerUser int
+	sessions   map[string]*Session
+	// queue holds the waiting ticket IDs in arrival order
+	queue         []string
+	tickets       map[string]*memoryTicket
+	ticketByToken map[string]string
+	// revokedTokens maps a jti to the expiry of its entry
+	revokedTokens map[string]time.Time
+	revokedBefore map[uint]userRevocation
+}
+
+type memoryTicket struct {
+	session   Session
+	status    TicketStatus
+	expiresAt time.Time
+}
+
+type userRevocation struct {
+	revokedBefore time.Time
+	expiresAt     time.Time
+}
+
+func NewMemorySessionManager(config Config) ISessionManager {
+	return &MemorySessionManager{
+		limit:         config.Limit,
+		maxPerUser:    config.MaxPerUser,
+		sessions:      make(map[string]*Session),
+		tickets:       make(map[string]*memoryTicket),
+		ticketByToken: make(map[string]string),
+		revokedTokens: make(map[string]time.Time),
+		revokedBefore: make(map[uint]userRevocation),
+	}
+}
+
+func (s *MemorySessionManager) TouchSession(session Session) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	current, ok := s.sessions[session.Token]
+	if !ok || !current.ExpiresAt.After(now) {
+		return false, nil
+	}
+	current.IP = session.IP
+	current.UserAgent = session.UserAgent
+	current.LastSeenAt = now
+	current.ExpiresAt = now.Add(SessionTTL)
+	return true, nil
+}
+
+func (s *MemorySessionManager) RequestSession(session Session) (*Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.purge(now)
+
+	if ticketID, ok := s.ticketByToken[session.Token]; ok {
+		ticket := s.tickets[ticketID]
+		if ticket.status == TicketWaiting {
+			return s.queueStatus(ticketID, now), nil
+		}
+		// an admitted ticket is done once its session ended
+		if current, ok := s.sessions[session.Token]; ok {
+			current.LastSeenAt = now
+			current.ExpiresAt = now.Add(SessionTTL)
+			return nil, nil
+		}
+	}
+
+	if s.register(session, now, true) {
+		return nil, nil
+	}
+
+	ticketID, err := newTicketID()
+	if err != nil {
+		return nil, err
+	}
+	if previous, ok := s.ticketByToken[session.Token]; ok {
+		delete(s.tickets, previous)
+	}
+	s.tickets[ticketID] = &memoryTicket{session: session, status: TicketWaiting, expiresAt: now.Add(TicketTTL)}
+	s.ticketByToken[session.Token] = ticketID
+	s.queue = append(s.queue, ticketID)
+	log.Println("Session queued : Ticket = ", ticketID)
+
+	return s.queueStatus(ticketID, now), nil
+}
+
+func (s *MemorySessionManager) QueueStatus(ticketID string) (*Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.purge(now)
+	return s.queueStatus(ticketID, now), nil
+}
+
+func (s *MemorySessionManager) AdmitWaiting() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.purge(now)
+
+	admitted := 0
+	for len(s.queue) > 0 {
+		ticket := s.tickets[s.queue[0]]
+		if !s.register(ticket.session, now, false) {
+			break
+		}
+		ticket.status = TicketAdmitted
+		// the admitted status stays visible while the session lives
+		ticket.expiresAt = now.Add(SessionTTL)
+		s.queue = s.queue[1:]
+		admitted++
+	}
+	return admitted, nil
+}
+
+func (s *MemorySessionManager) ListUserSessions(userID uint) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []Session{}
+	for _, session := range s.userSessions(userID, time.Now()) {
+		list = append(list, *session)
+	}
+	return list, nil
+}
+
+func (s *MemorySessionManager) DeleteSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+	return nil
+}
+
+func (s *MemorySessionManager) DeleteUserSessions(userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionManager) RevokeToken(jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokedTokens[jti] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *MemorySessionManager) RevokeUserTokens(userID uint, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.revokedBefore[userID] = userRevocation{revokedBefore: now, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemorySessionManager) IsTokenRevoked(jti string, userID uint, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if jti != "" {
+		if expiresAt, ok := s.revokedTokens[jti]; ok && expiresAt.After(now) {
+			return true, nil
+		}
+	}
+
+	revocation, ok := s.revokedBefore[userID]
+	if !ok || !revocation.expiresAt.After(now) {
+		return false, nil
+	}
+	// iat has second precision, so a token issued in the same second as the logout is rejected as well
+	return issuedAt.Unix() <= revocation.revokedBefore.Unix(), nil
+}
+
+// register takes a slot for the session like the registerSession script, false when there is none.
+// The caller holds the lock and has purged ended sessions.
+func (s *MemorySessionManager) register(session Session, now time.Time, yieldToQueue bool) bool {
+	if _, ok := s.sessions[session.Token]; ok {
+		return true
+	}
+
+	owned := s.userSessions(session.UserID, now)
+	replacing := s.maxPerUser > 0 && len(owned) >= s.maxPerUser
+	if !replacing {
+		if yieldToQueue && len(s.queue) > 0 {
+			return false
+		}
+		if len(s.sessions) >= s.limit {
+			return false
+		}
+	}
+
+	// end the oldest sessions of the user beyond the cap
+	for s.maxPerUser > 0 && len(owned) >= s.maxPerUser {
+		delete(s.sessions, owned[0].Token)
+		owned = owned[1:]
+	}
+	s.sessions[session.Token] = &Session{
+		Token:      session.Token,
+		UserID:     session.UserID,
+		IP:         session.IP,
+		UserAgent:  session.UserAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionTTL),
+	}
+	return true
+}
+
+// userSessions returns the live sessions of the user, oldest first.
+func (s *MemorySessionManager) userSessions(userID uint, now time.Time) []*Session {
+	var owned []*Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			owned = append(owned, session)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].CreatedAt.Before(owned[j].CreatedAt)
+	})
+	return owned
+}
+
+// queueStatus reports the ticket, the caller holds the lock and has purged expired tickets.
+func (s *MemorySessionManager) queueStatus(ticketID string, now time.Time) *Ticket {
+	ticket, ok := s.tickets[ticketID]
+	if !ok {
+		return &Ticket{ID: ticketID, Status: TicketExpired}
+	}
+	if ticket.status != TicketWaiting {
+		return &Ticket{ID: ticketID, Status: ticket.status}
+	}
+
+	var position int64
+	for i, id := range s.queue {
+		if id == ticketID {
+			position = int64(i + 1)
+			break
+		}
+	}
+	ticket.expiresAt = now.Add(TicketTTL)
+
+	expiries := make([]time.Time, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		expiries = append(expiries, session.ExpiresAt)
+	}
+	sort.Slice(expiries, func(i, j int) bool {
+		return expiries[i].Before(expiries[j])
+	})
+	wait, _ := estimateWait(position, int64(s.limit), func(index int64) (time.Time, error) {
+		if index >= int64(len(expiries)) {
+			return time.Time{}, nil
+		}
+		return expiries[index], nil
+	})
+	return &Ticket{ID: ticketID, Status: TicketWaiting, Position: position, EstimatedWait: wait}
+}
+
+// purge drops ended sessions, abandoned tickets and expired denylist entries, the caller holds the lock.
+func (s *MemorySessionManager) purge(now time.Time) {
+	for token, session := range s.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(s.sessions, token)
+		}
+	}
+
+	for ticketID, ticket := range s.tickets {
+		if !ticket.expiresAt.After(now) {
+			delete(s.tickets, ticketID)
+			if s.ticketByToken[ticket.session.Token] == ticketID {
+				delete(s.ticketByToken, ticket.session.Token)
+			}
+		}
+	}
+	queue := s.queue[:0]
+	for _, ticketID := range s.queue {
+		if _, ok := s.tickets[ticketID]; ok {
+			queue = append(queue, ticketID)
+		}
+	}
+	s.queue = queue
+
+	for jti, expiresAt := range s.revokedTokens {
+		if !expiresAt.After(now) {
+			delete(s.revokedTokens, jti)
+		}
+	}
+	for userID, revocation := range s.revokedBefore {
+		if !revocation.expiresAt.After(now) {
+			delete(s.revokedBefore, userID)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package sessions
+
+import (
+	"errors"
+	"gin-freemarket/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// admissionLockKey is the advisory lock serializing admissions, so that concurrent requests
+// cannot overshoot the limit
+const admissionLockKey = 7355001
+
+// PostgresSessionManager keeps the sessions in Postgres, shared by every instance of the app without Redis.
+// Ended sessions and abandoned tickets are ignored by every query and removed by PurgeExpired, see StartPurgeLoop.
+type PostgresSessionManager struct {
+	db         *gorm.DB
+	limit      int
+	maxPerUser int
+}
+
+func NewPostgresSessionManager(db *gorm.DB, config Config) ISessionManager {
+	return &PostgresSessionManager{db: db, limit: config.Limit, maxPerUser: config.MaxPerUser}
+}
+
+// PurgeExpired deletes ended sessions, abandoned tickets and the denylist entries of expired tokens.
+func (s *PostgresSessionManager) PurgeExpired() error {
+	now := time.Now()
+	for _, model := range []interface{}{&models.UserSession{}, &models.SessionTicket{}, &models.RevokedAccessToken{}, &models.UserTokenCutoff{}} {
+		if err := s.db.Where("expires_at <= ?", now).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresSessionManager) TouchSession(session Session) (bool, error) {
+	now := time.Now()
+	result := s.db.Model(&models.UserSession{}).
+		Where("token = ? AND expires_at > ?", session.Token, now).
+		Updates(map[string]interface{}{
+			"ip":           session.IP,
+			"user_agent":   session.UserAgent,
+			"last_seen_at": now,
+			"expires_at":   now.Add(SessionTTL),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *PostgresSessionManager) RequestSession(session Session) (*Ticket, error) {
+	var ticket *Ticket
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockAdmission(tx); err != nil {
+			return err
+		}
+		now := time.Now()
+
+		var existing models.SessionTicket
+		err := tx.Where("token = ? AND expires_at > ?", session.Token, now).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			if TicketStatus(existing.Status) == TicketWaiting {
+				ticket, err = s.queueStatus(tx, existing.TicketID)
+				return err
+			}
+			// an admitted ticket is done once its session ended
+			alive, err := s.touch(tx, session, now)
+			if err != nil || alive {
+				return err
+			}
+		}
+
+		registered, err := s.register(tx, session, now, true)
+		if err != nil || registered {
+			return err
+		}
+
+		ticketID, err := newTicketID()
+		if err != nil {
+			return err
+		}
+		// an expired or finished ticket of the token lost its place
+		if err := tx.Where("token = ?", session.Token).Delete(&models.SessionTicket{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.SessionTicket{
+			TicketID:  ticketID,
+			Token:     session.Token,
+			UserID:    session.UserID,
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			Status:    string(TicketWaiting),
+			ExpiresAt: now.Add(TicketTTL),
+		}).Error; err != nil {
+			return err
+		}
+		log.Println("Session queued : Ticket = ", ticketID)
+
+		ticket, err = s.queueStatus(tx, ticketID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+func (s *PostgresSessionManager) QueueStatus(ticketID string) (*Ticket, error) {
+	return s.queueStatus(s.db, ticketID)
+}
+
+func (s *PostgresSessionManager) AdmitWaiting() (int, error) {
+	admitted := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockAdmission(tx); err != nil {
+			return err
+		}
+		now := time.Now()
+
+		for {
+			var head models.SessionTicket
+			err := tx.Where("status = ? AND expires_at > ?", TicketWaiting, now).Order("id").First(&head).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			registered, err := s.register(tx, Session{
+				Token:     head.Token,
+				UserID:    head.UserID,
+				IP:        head.IP,
+				UserAgent: head.UserAgent,
+			}, now, false)
+			if err != nil || !registered {
+				return err
+			}
+			// the admitted status stays visible while the session lives
+			if err := tx.Model(&head).Updates(map[string]interface{}{
+				"status":     string(TicketAdmitted),
+				"expires_at": now.Add(SessionTTL),
+			}).Error; err != nil {
+				return err
+			}
+			admitted++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return admitted, nil
+}
+
+func (s *PostgresSessionManager) ListUserSessions(userID uint) ([]Session, error) {
+	var rows []models.UserSession
+	if err := s.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("created_at").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	list := make([]Session, 0, len(rows))
+	for _, row := range rows {
+		list = append(list, Session{
+			Token:      row.Token,
+			UserID:     row.UserID,
+			IP:         row.IP,
+			UserAgent:  row.UserAgent,
+			CreatedAt:  row.CreatedAt,
+			LastSeenAt: row.LastSeenAt,
+			ExpiresAt:  row.ExpiresAt,
+		})
+	}
+	return list, nil
+}
+
+func (s *PostgresSessionManager) DeleteSession(token string) error {
+	return s.db.Where("token = ?", token).Delete(&models.UserSession{}).Error
+}
+
+func (s *PostgresSessionManager) DeleteUserSessions(userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.UserSession{}).Error
+}
+
+func (s *PostgresSessionManager) RevokeToken(jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&models.RevokedAccessToken{JTI: jti, ExpiresAt: time.Now().Add(ttl)}).Error
+}
+
+func (s *PostgresSessionManager) RevokeUserTokens(userID uint, ttl time.Duration) error {
+	now := time.Now()
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&models.UserTokenCutoff{UserID: userID, RevokedBefore: now, ExpiresAt: now.Add(ttl)}).Error
+}
+
+func (s *PostgresSessionManager) IsTokenRevoked(jti string, userID uint, issuedAt time.Time) (bool, error) {
+	now := time.Now()
+	if jti != "" {
+		var revoked int64
+		if err := s.db.Model(&models.RevokedAccessToken{}).Where("jti = ? AND expires_at > ?", jti, now).Count(&revoked).Error; err != nil {
+			return false, err
+		}
+		if revoked > 0 {
+			return true, nil
+		}
+	}
+
+	var cutoff models.UserTokenCutoff
+	err := s.db.Where("user_id = ? AND expires_at > ?", userID, now).First(&cutoff).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// iat has second precision, so a token issued in the same second as the logout is rejected as well
+	return issuedAt.Unix() <= cutoff.RevokedBefore.Unix(), nil
+}
+
+func lockAdmission(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", admissionLockKey).Error
+}
+
+func (s *PostgresSessionManager) touch(tx *gorm.DB, session Session, now time.Time) (bool, error) {
+	result := tx.Model(&models.UserSession{}).
+		Where("token = ? AND expires_at > ?", session.Token, now).
+		Updates(map[string]interface{}{"last_seen_at": now, "expires_at": now.Add(SessionTTL)})
+	return result.RowsAffected == 1, result.Error
+}
+
+// register takes a slot for the session like the registerSession script, false when there is none.
+// The caller holds the admission lock.
+func (s *PostgresSessionManager) register(tx *gorm.DB, session Session, now time.Time, yieldToQueue bool) (bool, error) {
+	// ended sessions free their slots and their tokens
+	if err := tx.Where("expires_at <= ?", now).Delete(&models.UserSession{}).Error; err != nil {
+		return false, err
+	}
+
+	var existing int64
+	if err := tx.Model(&models.UserSession{}).Where("token = ?", session.Token).Count(&existing).Error; err != nil {
+		return false, err
+	}
+	if existing > 0 {
+		return true, nil
+	}
+
+	var owned []models.UserSession
+	if s.maxPerUser > 0 {
+		if err := tx.Where("user_id = ?", session.UserID).Order("created_at").Find(&owned).Error; err != nil {
+			return false, err
+		}
+	}
+	replacing := s.maxPerUser > 0 && len(owned) >= s.maxPerUser
+	if !replacing {
+		if yieldToQueue {
+			var waiting int64
+			if err := tx.Model(&models.SessionTicket{}).Where("status = ? AND expires_at > ?", TicketWaiting, now).Count(&waiting).Error; err != nil {
+				return false, err
+			}
+			if waiting > 0 {
+				return false, nil
+			}
+		}
+		var count int64
+		if err := tx.Model(&models.UserSession{}).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count >= int64(s.limit) {
+			return false, nil
+		}
+	}
+
+	// end the oldest sessions of the user beyond the cap
+	for s.maxPerUser > 0 && len(owned) >= s.maxPerUser {
+		if err := tx.Delete(&owned[0]).Error; err != nil {
+			return false, err
+		}
+		owned = owned[1:]
+	}
+	if err := tx.Create(&models.UserSession{
+		Token:      session.Token,
+		UserID:     session.UserID,
+		IP:         session.IP,
+		UserAgent:  session.UserAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionTTL),
+	}).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *PostgresSessionManager) queueStatus(db *gorm.DB, ticketID string) (*Ticket, error) {
+	now := time.Now()
+	ticket := &Ticket{ID: ticketID, Status: TicketExpired}
+
+	var row models.SessionTicket
+	err := db.Where("ticket_id = ? AND expires_at > ?", ticketID, now).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ticket, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ticket.Status = TicketStatus(row.Status)
+	if ticket.Status != TicketWaiting {
+		return ticket, nil
+	}
+
+	if err := db.Model(&models.SessionTicket{}).
+		Where("status = ? AND expires_at > ? AND id <= ?", TicketWaiting, now, row.ID).
+		Count(&ticket.Position).Error; err != nil {
+		return nil, err
+	}
+	ticket.EstimatedWait, err = estimateWait(ticket.Position, int64(s.limit), func(index int64) (time.Time, error) {
+		var expiring []models.UserSession
+		if err := db.Where("expires_at > ?", now).Order("expires_at").Offset(int(index)).Limit(1).Find(&expiring).Error; err != nil || len(expiring) == 0 {
+			return time.Time{}, err
+		}
+		return expiring[0].ExpiresAt, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&row).Update("expires_at", now.Add(TicketTTL)).Error; err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
@@ -0,0 +1,158 @@
+package sessions
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// SessionExpiryKey is a sorted set of the session tokens scored by their expiry in unix milliseconds,
+	// see scripts.go for the other keys.
+	SessionExpiryKey   = "session_expiry"
+	SessionInfoPrefix  = "session_info:"
+	UserSessionsPrefix = "user_sessions:"
+	SessionLimitKey    = "session_limit"
+	// RevokedTokenPrefix + jti is set while a logged out access token would still be valid
+	RevokedTokenPrefix = "revoked_jti:"
+	// RevokedBeforePrefix + user ID holds the time of the last logout-all, tokens issued until then are rejected
+	RevokedBeforePrefix = "revoked_before:"
+)
+
+// RedisSessionManager keeps the sessions in Redis, shared by every instance of the app.
+// Its limit is session_limit, set by the admin app.
+type RedisSessionManager struct {
+	redis *redis.Client
+	// maxPerUser is the number of sessions a user may hold, a new one ends the oldest. 0 is no cap.
+	maxPerUser int
+}
+
+func NewRedisSessionManager(client *redis.Client, config Config) ISessionManager {
+	return &RedisSessionManager{
+		redis:      client,
+		maxPerUser: config.MaxPerUser,
+	}
+}
+
+func (s *RedisSessionManager) TouchSession(session Session) (bool, error) {
+	alive, err := touchSession.Run(context.Background(), s.redis, []string{SessionExpiryKey},
+		session.Token, time.Now().UnixMilli(), SessionTTL.Milliseconds(), session.IP, session.UserAgent,
+		SessionInfoPrefix, UserSessionsPrefix).Int()
+	return alive == 1, err
+}
+
+// registerSession takes a slot for the session, false when the session limit is reached.
+// With yieldToQueue a free slot is left to waiting tickets.
+func (s *RedisSessionManager) registerSession(ctx context.Context, session Session, yieldToQueue bool) (bool, error) {
+	yield := "0"
+	if yieldToQueue {
+		yield = "1"
+	}
+	registered, err := registerSession.Run(ctx, s.redis,
+		[]string{SessionExpiryKey, SessionLimitKey, SessionQueueKey},
+		session.Token, time.Now().UnixMilli(), SessionTTL.Milliseconds(), yield,
+		session.UserID, session.IP, session.UserAgent, s.maxPerUser, SessionInfoPrefix, UserSessionsPrefix).Int()
+	if err != nil {
+		return false, err
+	}
+	if registered == 0 {
+		log.Println("session limit is reached")
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *RedisSessionManager) ListUserSessions(userID uint) ([]Session, error) {
+	ctx := context.Background()
+	tokens, err := s.redis.ZRange(ctx, UserSessionsPrefix+strconv.FormatUint(uint64(userID), 10), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	list := make([]Session, 0, len(tokens))
+	for _, token := range tokens {
+		expiry, err := s.redis.ZScore(ctx, SessionExpiryKey, token).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		expiresAt := time.UnixMilli(int64(expiry))
+		if !expiresAt.After(now) {
+			continue
+		}
+
+		info, err := s.redis.HGetAll(ctx, SessionInfoPrefix+token).Result()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Session{
+			Token:      token,
+			UserID:     userID,
+			IP:         info["ip"],
+			UserAgent:  info["user_agent"],
+			CreatedAt:  parseUnixMilli(info["created_at"]),
+			LastSeenAt: parseUnixMilli(info["last_seen_at"]),
+			ExpiresAt:  expiresAt,
+		})
+	}
+	return list, nil
+}
+
+func (s *RedisSessionManager) DeleteSession(token string) error {
+	return deleteSession.Run(context.Background(), s.redis, []string{SessionExpiryKey},
+		token, SessionInfoPrefix, UserSessionsPrefix).Err()
+}
+
+func (s *RedisSessionManager) DeleteUserSessions(userID uint) error {
+	tokens, err := s.redis.ZRange(context.Background(), UserSessionsPrefix+strconv.FormatUint(uint64(userID), 10), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := s.DeleteSession(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisSessionManager) RevokeToken(jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	return s.redis.Set(context.Background(), RevokedTokenPrefix+jti, "1", ttl).Err()
+}
+
+func (s *RedisSessionManager) RevokeUserTokens(userID uint, ttl time.Duration) error {
+	return s.redis.Set(context.Background(), RevokedBeforePrefix+strconv.FormatUint(uint64(userID), 10),
+		strconv.FormatInt(time.Now().Unix(), 10), ttl).Err()
+}
+
+func (s *RedisSessionManager) IsTokenRevoked(jti string, userID uint, issuedAt time.Time) (bool, error) {
+	ctx := context.Background()
+	if jti != "" {
+		revoked, err := s.redis.Exists(ctx, RevokedTokenPrefix+jti).Result()
+		if err != nil {
+			return false, err
+		}
+		if revoked > 0 {
+			return true, nil
+		}
+	}
+
+	revokedBefore, err := s.redis.Get(ctx, RevokedBeforePrefix+strconv.FormatUint(uint64(userID), 10)).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// iat has second precision, so a token issued in the same second as the logout is rejected as well
+	return issuedAt.Unix() <= revokedBefore, nil
+}
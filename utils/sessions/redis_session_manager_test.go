@@ -0,0 +1,124 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestRedis starts an in-process Redis with session_limit set to limit.
+func newTestRedis(t *testing.T, limit int) *redis.Client {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	if err := client.Set(context.Background(), SessionLimitKey, limit, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// TestRedisSessionLimitUnderConcurrency races registerSession and admitWaiting against each other
+// while sessions end, and checks that session_expiry never holds more than session_limit tokens.
+func TestRedisSessionLimitUnderConcurrency(t *testing.T) {
+	const (
+		limit   = 5
+		clients = 60
+		rounds  = 3
+	)
+	client := newTestRedis(t, limit)
+	manager := NewRedisSessionManager(client, Config{MaxPerUser: 1})
+	ctx := context.Background()
+
+	var overshoot atomic.Int64
+	checkLimit := func() {
+		count, err := client.ZCard(ctx, SessionExpiryKey).Result()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if count > limit {
+			overshoot.Store(count)
+		}
+	}
+
+	// a sampler watches the slots between the scripts of the clients
+	done := make(chan struct{})
+	var sampler sync.WaitGroup
+	sampler.Add(1)
+	go func() {
+		defer sampler.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				checkLimit()
+			}
+		}
+	}()
+
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				session := Session{Token: fmt.Sprintf("token-%d-%d", i, round), UserID: uint(i + 1), IP: "127.0.0.1"}
+				deadline := time.Now().Add(10 * time.Second)
+				for {
+					ticket, err := manager.RequestSession(session)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					checkLimit()
+					if ticket == nil {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Errorf("ticket %s was not admitted", ticket.ID)
+						return
+					}
+					if _, err := manager.AdmitWaiting(); err != nil {
+						t.Error(err)
+						return
+					}
+					checkLimit()
+				}
+
+				admitted.Add(1)
+				time.Sleep(time.Millisecond)
+				if err := manager.DeleteSession(session.Token); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := manager.AdmitWaiting(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	sampler.Wait()
+
+	if count := overshoot.Load(); count > 0 {
+		t.Fatalf("session_expiry held %d sessions, limit is %d", count, limit)
+	}
+	if got := admitted.Load(); got != clients*rounds {
+		t.Fatalf("admitted %d sessions, want %d", got, clients*rounds)
+	}
+	if count := client.ZCard(ctx, SessionExpiryKey).Val(); count != 0 {
+		t.Fatalf("%d sessions left after every client logged out", count)
+	}
+}
@@ -0,0 +1,150 @@
+package sessions
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Waiting room: when all session slots are taken, requests without a session get a ticket in a FIFO queue
+// instead of an error. Tickets are admitted in order as slots free up, admission registers the session of
+// the ticket's token, so the client only has to retry once its ticket says admitted.
+// Newcomers yield to waiting tickets inside the registerSession script, so nobody skips the line.
+const (
+	// SessionQueueKey is a sorted set of ticket IDs scored by their arrival order
+	SessionQueueKey    = "session_queue"
+	SessionQueueSeqKey = "session_queue_seq"
+	// SessionTicketPrefix + ticket ID is a hash with the session to register and the status of the ticket
+	SessionTicketPrefix = "session_ticket:"
+	// SessionTicketTokenPrefix + token is the ticket ID of the token, so that retries keep their place
+	SessionTicketTokenPrefix = "session_ticket_token:"
+)
+
+func (s *RedisSessionManager) RequestSession(session Session) (*Ticket, error) {
+	ctx := context.Background()
+
+	ticketID, err := s.redis.Get(ctx, SessionTicketTokenPrefix+session.Token).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err == nil {
+		ticket, err := s.QueueStatus(ticketID)
+		if err != nil {
+			return nil, err
+		}
+		if ticket.Status == TicketWaiting {
+			return ticket, nil
+		}
+		// an admitted ticket is done once its session ended, an expired ticket lost its place,
+		// either way the token asks for a slot again
+		if ticket.Status == TicketAdmitted {
+			alive, err := s.TouchSession(session)
+			if err != nil || alive {
+				return nil, err
+			}
+		}
+	}
+
+	registered, err := s.registerSession(ctx, session, true)
+	if err != nil {
+		return nil, err
+	}
+	if registered {
+		return nil, nil
+	}
+	return s.enqueue(ctx, session)
+}
+
+func (s *RedisSessionManager) QueueStatus(ticketID string) (*Ticket, error) {
+	ctx := context.Background()
+	ticket := &Ticket{ID: ticketID, Status: TicketExpired}
+
+	status, err := s.redis.HGet(ctx, SessionTicketPrefix+ticketID, "status").Result()
+	if err == redis.Nil {
+		return ticket, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ticket.Status = TicketStatus(status)
+	if ticket.Status != TicketWaiting {
+		return ticket, nil
+	}
+
+	rank, err := s.redis.ZRank(ctx, SessionQueueKey, ticketID).Result()
+	if err == redis.Nil {
+		// admitted between the two reads
+		ticket.Status = TicketAdmitted
+		return ticket, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ticket.Position = rank + 1
+	ticket.EstimatedWait, err = s.estimateWait(ctx, ticket.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.redis.HGet(ctx, SessionTicketPrefix+ticketID, "token").Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	pipe := s.redis.TxPipeline()
+	pipe.Expire(ctx, SessionTicketPrefix+ticketID, TicketTTL)
+	pipe.Expire(ctx, SessionTicketTokenPrefix+token, TicketTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+func (s *RedisSessionManager) AdmitWaiting() (int, error) {
+	return admitWaiting.Run(context.Background(), s.redis,
+		[]string{SessionExpiryKey, SessionLimitKey, SessionQueueKey},
+		time.Now().UnixMilli(), SessionTTL.Milliseconds(), SessionTicketPrefix, SessionTicketTokenPrefix,
+		s.maxPerUser, SessionInfoPrefix, UserSessionsPrefix).Int()
+}
+
+func (s *RedisSessionManager) enqueue(ctx context.Context, session Session) (*Ticket, error) {
+	ticketID, err := newTicketID()
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := s.redis.Incr(ctx, SessionQueueSeqKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := s.redis.TxPipeline()
+	// the ticket keeps what admission needs to register the session
+	pipe.HSet(ctx, SessionTicketPrefix+ticketID,
+		"token", session.Token, "user_id", session.UserID, "ip", session.IP, "user_agent", session.UserAgent,
+		"status", string(TicketWaiting))
+	pipe.Expire(ctx, SessionTicketPrefix+ticketID, TicketTTL)
+	pipe.Set(ctx, SessionTicketTokenPrefix+session.Token, ticketID, TicketTTL)
+	pipe.ZAdd(ctx, SessionQueueKey, &redis.Z{Score: float64(seq), Member: ticketID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	log.Println("Session queued : Ticket = ", ticketID)
+
+	return s.QueueStatus(ticketID)
+}
+
+// estimateWait reads the limit and the expiry of the slot from Redis, see the package level estimateWait.
+func (s *RedisSessionManager) estimateWait(ctx context.Context, position int64) (time.Duration, error) {
+	limit, err := s.redis.Get(ctx, SessionLimitKey).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return estimateWait(position, limit, func(index int64) (time.Time, error) {
+		expiring, err := s.redis.ZRangeWithScores(ctx, SessionExpiryKey, index, index).Result()
+		if err != nil || len(expiring) == 0 {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(int64(expiring[0].Score)), nil
+	})
+}
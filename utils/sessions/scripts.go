@@ -0,0 +1,145 @@
+package sessions
+
+import "github.com/go-redis/redis/v8"
+
+// Sessions are changed by Lua scripts only, so that counting a slot and taking it happen in one step and
+// concurrent requests cannot overshoot session_limit. Keys of single sessions, users and tickets are derived
+// from prefixes passed in ARGV, which ties the scripts to a single Redis node.
+//
+//	session_expiry            sorted set of tokens by expiry in unix ms, the global slots
+//	session_info:<token>      hash with user_id, ip, user_agent, created_at and last_seen_at
+//	user_sessions:<user ID>   sorted set of the user's tokens by creation in unix ms
+//
+// Ended sessions are dropped from session_expiry before counting and from user_sessions before
+// checking the per-user cap, their info hash expires on its own.
+
+// sessionFunctions are shared by the scripts adding sessions.
+// sessions is the session_expiry key, info and users the session_info: and user_sessions: prefixes.
+const sessionFunctions = `
+local function live_user_sessions(sessions, userKey, now)
+	for _, token in ipairs(redis.call('ZRANGE', userKey, 0, -1)) do
+		local expiry = redis.call('ZSCORE', sessions, token)
+		if not expiry or tonumber(expiry) <= now then
+			redis.call('ZREM', userKey, token)
+		end
+	end
+	return redis.call('ZCARD', userKey)
+end
+
+-- add_session ends the oldest sessions of the user beyond the cap, then adds the new one
+local function add_session(sessions, info, users, token, user, ip, agent, now, ttl, maxPerUser)
+	local userKey = users .. user
+	while maxPerUser > 0 and redis.call('ZCARD', userKey) >= maxPerUser do
+		local oldest = redis.call('ZRANGE', userKey, 0, 0)[1]
+		redis.call('ZREM', userKey, oldest)
+		redis.call('ZREM', sessions, oldest)
+		redis.call('DEL', info .. oldest)
+	end
+	redis.call('ZADD', sessions, now + ttl, token)
+	redis.call('ZADD', userKey, now, token)
+	redis.call('PEXPIRE', userKey, ttl)
+	redis.call('HSET', info .. token, 'user_id', user, 'ip', ip, 'user_agent', agent, 'created_at', now, 'last_seen_at', now)
+	redis.call('PEXPIRE', info .. token, ttl)
+end
+`
+
+// registerSession admits the session when a slot is free. A user at the per-user cap replaces their oldest
+// session, which needs no free slot.
+// KEYS: session_expiry, session_limit, session_queue.
+// ARGV: token, now ms, TTL ms, "1" to yield to waiting tickets, user ID, IP, user agent, max per user,
+// session_info: prefix, user_sessions: prefix.
+// Returns 1 when the token has a session, 0 when there is no slot for it.
+var registerSession = redis.NewScript(sessionFunctions + `
+local now, ttl = tonumber(ARGV[2]), tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 1
+end
+local limit = tonumber(redis.call('GET', KEYS[2]))
+if not limit then
+	return redis.error_reply('session_limit is not set')
+end
+local maxPerUser = tonumber(ARGV[8])
+local replacing = maxPerUser > 0 and live_user_sessions(KEYS[1], ARGV[10] .. ARGV[5], now) >= maxPerUser
+if not replacing then
+	if ARGV[4] == '1' and redis.call('ZCARD', KEYS[3]) > 0 then
+		return 0
+	end
+	if redis.call('ZCARD', KEYS[1]) >= limit then
+		return 0
+	end
+end
+add_session(KEYS[1], ARGV[9], ARGV[10], ARGV[1], ARGV[5], ARGV[6], ARGV[7], now, ttl, maxPerUser)
+return 1
+`)
+
+// touchSession slides the expiry of a live session and records the request.
+// KEYS: session_expiry. ARGV: token, now ms, TTL ms, IP, user agent, session_info: prefix, user_sessions: prefix.
+// Returns 1 when the session is live, 0 when it has ended.
+var touchSession = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local expiry = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not expiry or tonumber(expiry) <= now then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+local info = ARGV[6] .. ARGV[1]
+if redis.call('EXISTS', info) == 1 then
+	redis.call('HSET', info, 'last_seen_at', now, 'ip', ARGV[4], 'user_agent', ARGV[5])
+	redis.call('PEXPIRE', info, ARGV[3])
+	redis.call('PEXPIRE', ARGV[7] .. redis.call('HGET', info, 'user_id'), ARGV[3])
+end
+return 1
+`)
+
+// deleteSession ends a session and removes it from its user's index.
+// KEYS: session_expiry. ARGV: token, session_info: prefix, user_sessions: prefix.
+var deleteSession = redis.NewScript(`
+local info = ARGV[2] .. ARGV[1]
+local user = redis.call('HGET', info, 'user_id')
+if user then
+	redis.call('ZREM', ARGV[3] .. user, ARGV[1])
+end
+redis.call('DEL', info)
+return redis.call('ZREM', KEYS[1], ARGV[1])
+`)
+
+// admitWaiting admits tickets from the head of the queue while they can get a slot, like registerSession.
+// Tickets whose hash expired were abandoned and are dropped.
+// KEYS: session_expiry, session_limit, session_queue.
+// ARGV: now ms, TTL ms, session_ticket: prefix, session_ticket_token: prefix, max per user,
+// session_info: prefix, user_sessions: prefix.
+var admitWaiting = redis.NewScript(sessionFunctions + `
+local now, ttl = tonumber(ARGV[1]), tonumber(ARGV[2])
+local maxPerUser = tonumber(ARGV[5])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local limit = tonumber(redis.call('GET', KEYS[2]))
+if not limit then
+	return 0
+end
+local admitted = 0
+while true do
+	local head = redis.call('ZRANGE', KEYS[3], 0, 0)
+	if #head == 0 then
+		break
+	end
+	local ticket = ARGV[3] .. head[1]
+	local fields = redis.call('HMGET', ticket, 'token', 'user_id', 'ip', 'user_agent')
+	-- tickets queued before sessions carried their client have only the token
+	local token, user, ip, agent = fields[1], fields[2] or '0', fields[3] or '', fields[4] or ''
+	if token then
+		local replacing = maxPerUser > 0 and live_user_sessions(KEYS[1], ARGV[7] .. user, now) >= maxPerUser
+		if not replacing and redis.call('ZCARD', KEYS[1]) >= limit then
+			break
+		end
+		add_session(KEYS[1], ARGV[6], ARGV[7], token, user, ip, agent, now, ttl, maxPerUser)
+		redis.call('HSET', ticket, 'status', 'admitted')
+		-- the admitted status stays visible while the session lives
+		redis.call('PEXPIRE', ticket, ttl)
+		redis.call('PEXPIRE', ARGV[4] .. token, ttl)
+		admitted = admitted + 1
+	end
+	redis.call('ZREM', KEYS[3], head[1])
+end
+return admitted
+`)
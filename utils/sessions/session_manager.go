@@ -1,140 +1,192 @@
+// Package sessions limits the number of concurrent sessions of the purchase APIs. Requests arriving while
+// all slots are taken wait in a FIFO waiting room, and are admitted in order as sessions end.
+// The package also keeps the denylists of logged out access tokens.
+//
+// Sessions are stored in Redis, Postgres or in memory, see NewSessionManagerFromEnv.
 package sessions
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"gin-freemarket/utils/env"
 	"log"
 	"os"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
 )
 
 type ISessionManager interface {
-	SessionExists(token string) (bool, error)
-	RegisterSession(token string) error
+	// TouchSession slides the expiry of the session, false when it has ended
+	TouchSession(session Session) (bool, error)
+	// RequestSession registers a session for the token, or queues it when no slot is free.
+	// A nil ticket means the token has a session. Newcomers queue behind waiting tickets, so nobody skips the line.
+	RequestSession(session Session) (*Ticket, error)
+	// QueueStatus reports the ticket's status, its position and the estimated wait, and keeps a waiting ticket alive.
+	QueueStatus(ticketID string) (*Ticket, error)
+	// AdmitWaiting admits tickets from the head of the queue while they can get a slot
+	// and returns the number of admitted tickets.
+	AdmitWaiting() (int, error)
+	// ListUserSessions returns the live sessions of the user, oldest first.
+	ListUserSessions(userID uint) ([]Session, error)
 	DeleteSession(token string) error
+	// DeleteUserSessions ends every session of the user.
+	DeleteUserSessions(userID uint) error
+	// RevokeToken puts the jti of an access token on the denylist. ttl should be the remaining lifetime
+	// of the token, the entry is useless once the token has expired anyway.
+	RevokeToken(jti string, ttl time.Duration) error
+	// RevokeUserTokens rejects every access token of the user issued until now.
+	// ttl should be the access token lifetime, by then all those tokens have expired.
+	RevokeUserTokens(userID uint, ttl time.Duration) error
+	// IsTokenRevoked checks an access token against both denylists.
+	IsTokenRevoked(jti string, userID uint, issuedAt time.Time) (bool, error)
 }
 
-var once sync.Once
-var singleInstance *SessionManager = nil
-
 const (
-	SessionHashKey    = "session"
-	SessionExpireHash = "session_expire"
-	SessionLimitKey   = "session_limit"
-	SessionTTL        = 30 * 60 * time.Second // 30 minutes
-
+	// SessionTTL is the idle timeout, every request extends the session by it
+	SessionTTL = 30 * 60 * time.Second // 30 minutes
+	// TicketTTL is how long a waiting ticket lives without being polled, abandoned tickets are skipped
+	TicketTTL = 2 * time.Minute
+	// QueuePollInterval is the interval clients are asked to poll at
+	QueuePollInterval = 5 * time.Second
 )
 
-type SessionManager struct {
-	redis *redis.Client
+// Session is one slot taken by a token, with the client it was last used from.
+type Session struct {
+	Token      string
+	UserID     uint
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
 }
 
-func GetSessionManager() (*SessionManager, error) {
-	once.Do(func() {
-		if singleInstance == nil {
-			singleInstance = &SessionManager{
-				redis: redis.NewClient(&redis.Options{
-					Addr: os.Getenv("REDIS_HOST"),
-				}),
-			}
-		}
+type TicketStatus string
 
-		// cleaning up hash in redis mannaging session keys by go routine
-		go func() {
-			ticker := time.NewTicker(1 * time.Minute)
-			defer ticker.Stop()
-
-			for range ticker.C {
-				ctx := context.Background()
-				keys, err := singleInstance.redis.HKeys(ctx, SessionHashKey).Result()
-				if err != nil {
-					log.Printf("Error getting session keys for cleanup: %v", err)
-					continue
-				}
-
-				for _, key := range keys {
-					// Check if corresponding TTL key exists
-					if exists, err := singleInstance.redis.Exists(ctx, key).Result(); err != nil {
-						log.Printf("Error checking TTL key existence for %s: %v", key, err)
-						continue
-					} else if exists == 0 {
-						// Remove from session hash if TTL key doesn't exist (expired)
-						err := singleInstance.redis.HDel(ctx, SessionHashKey, key).Err()
-						if err != nil {
-							log.Printf("Error deleting expired session key %s from hash: %v", key, err)
-						} else {
-							log.Printf("Cleaned up expired session key: %s", key)
-						}
-					}
-				}
-			}
-		}()
+const (
+	TicketWaiting  TicketStatus = "waiting"
+	TicketAdmitted TicketStatus = "admitted"
+	// TicketExpired is reported for unknown tickets and tickets not polled within TicketTTL
+	TicketExpired TicketStatus = "expired"
+)
 
-	})
-	return singleInstance, nil
+// Ticket is a place in the waiting room.
+type Ticket struct {
+	ID     string
+	Status TicketStatus
+	// Position is 1 for the next ticket to be admitted, 0 once admitted
+	Position      int64
+	EstimatedWait time.Duration
 }
 
-func (s *SessionManager) SessionExists(token string) (bool, error) {
-	exists, err := s.redis.HExists(context.Background(), SessionHashKey, token).Result()
-	if err != nil {
-		return false, err
+type Config struct {
+	// Limit is the number of sessions of the memory and postgres stores.
+	// The Redis store reads session_limit instead, which the admin app changes at runtime.
+	Limit int
+	// MaxPerUser is the number of sessions a user may hold, a new one ends the oldest. 0 is no cap.
+	MaxPerUser int
+}
+
+// LoadConfigFromEnv reads SESSION_LIMIT (100) and SESSION_MAX_PER_USER (3).
+func LoadConfigFromEnv() Config {
+	return Config{
+		Limit:      env.IntAtLeast("SESSION_LIMIT", 0, 100),
+		MaxPerUser: env.IntAtLeast("SESSION_MAX_PER_USER", 0, 3),
 	}
-	if exists {
-		// for the user already has a session
-		stillAlive, err := s.redis.Get(context.Background(), token).Result()
-		if err != nil {
-			if err == redis.Nil {
-				return false, nil
+}
+
+// NewSessionManagerFromEnv creates the store selected by SESSION_STORE: "redis" (the default) at REDIS_HOST,
+// "postgres", or "memory" for a single instance and local development.
+func NewSessionManagerFromEnv(db *gorm.DB) (ISessionManager, error) {
+	config := LoadConfigFromEnv()
+	switch os.Getenv("SESSION_STORE") {
+	case "", "redis":
+		return NewRedisSessionManager(redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_HOST")}), config), nil
+	case "postgres":
+		return NewPostgresSessionManager(db, config), nil
+	case "memory":
+		return NewMemorySessionManager(config), nil
+	default:
+		return nil, errors.New("unknown SESSION_STORE: " + os.Getenv("SESSION_STORE"))
+	}
+}
+
+// StartAdmissionLoop admits waiting tickets every interval, sessions expiring free their slots
+// without any request noticing it.
+func StartAdmissionLoop(manager ISessionManager, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			admitted, err := manager.AdmitWaiting()
+			if err != nil {
+				log.Printf("Error admitting waiting sessions: %v", err)
+				continue
+			}
+			if admitted > 0 {
+				log.Printf("Admitted %d waiting sessions", admitted)
 			}
-			return false, err
-		}
-		if stillAlive != "" {
-			return true, nil
 		}
+	}()
+}
+
+// StartPurgeLoop removes expired rows every interval from stores keeping them, which is the postgres store.
+// Redis and the memory store drop expired entries on their own, for them it does nothing.
+func StartPurgeLoop(manager ISessionManager, interval time.Duration) {
+	purger, ok := manager.(interface{ PurgeExpired() error })
+	if !ok {
+		return
 	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 
-	return false, nil
+		for range ticker.C {
+			if err := purger.PurgeExpired(); err != nil {
+				log.Printf("Error purging expired sessions: %v", err)
+			}
+		}
+	}()
 }
 
-func (s *SessionManager) RegisterSession(token string) (bool, error) {
-
-	// check there are still some space for new session (total number of session is less than session_limit set in redis)
-	sessionLimit, err := s.redis.Get(context.Background(), SessionLimitKey).Result()
-	if err != nil {
-		return false, err
+// estimateWait is the time until the slot of the position frees up. The ticket at position p takes the slot of the
+// session expiring ((p-1) mod limit)-th, after (p-1)/limit full rounds of sessions living SessionTTL.
+// It assumes no session is extended any more, activity makes the wait longer and logouts shorter.
+// slotExpiry returns the expiry of the session at the index in expiry order, zero when there is none.
+func estimateWait(position int64, limit int64, slotExpiry func(index int64) (time.Time, error)) (time.Duration, error) {
+	if limit <= 0 {
+		limit = 1
 	}
+	index, rounds := (position-1)%limit, (position-1)/limit
 
-	// get current number of sessions
-	sessionCount, err := s.redis.HLen(context.Background(), SessionHashKey).Result()
+	wait := time.Duration(rounds) * SessionTTL
+	expiresAt, err := slotExpiry(index)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-
-	limit, err := strconv.Atoi(sessionLimit)
-	if err != nil {
-		return false, err
+	if !expiresAt.IsZero() {
+		wait += max(time.Until(expiresAt), 0)
 	}
+	return wait, nil
+}
 
-	if int64(limit) < sessionCount {
-		log.Println("session limit is reached : ", sessionCount, " / ", limit)
-		return false, errors.New("session limit is reached")
+func newTicketID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-
-	// register new session
-	s.redis.HSet(context.Background(), SessionHashKey, token, "1")
-	// try
-	// > TTL token
-	// in redis cli to see session TTL(= Time to Live)
-	s.redis.Set(context.Background(), token, "", SessionTTL)
-	return true, nil
+	return hex.EncodeToString(b), nil
 }
 
-func (s *SessionManager) DeleteSession(token string) error {
-	s.redis.HDel(context.Background(), SessionHashKey, token)
-	s.redis.Del(context.Background(), token)
-	return nil
+func parseUnixMilli(value string) time.Time {
+	milli, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(milli)
 }
@@ -0,0 +1,276 @@
+package sessions
+
+import (
+	"fmt"
+	"gin-freemarket/models"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sessionStores opens an empty store of each kind. The postgres store needs SESSION_TEST_DATABASE_DSN,
+// its tables are emptied before every test.
+var sessionStores = []struct {
+	name string
+	open func(t *testing.T, config Config) ISessionManager
+}{
+	{"memory", func(t *testing.T, config Config) ISessionManager {
+		return NewMemorySessionManager(config)
+	}},
+	{"redis", func(t *testing.T, config Config) ISessionManager {
+		return NewRedisSessionManager(newTestRedis(t, config.Limit), config)
+	}},
+	{"postgres", func(t *testing.T, config Config) ISessionManager {
+		dsn := os.Getenv("SESSION_TEST_DATABASE_DSN")
+		if dsn == "" {
+			t.Skip("SESSION_TEST_DATABASE_DSN is not set")
+		}
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tables := []interface{}{&models.UserSession{}, &models.SessionTicket{}, &models.RevokedAccessToken{}, &models.UserTokenCutoff{}}
+		if err := db.AutoMigrate(tables...); err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range tables {
+			if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(table).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		return NewPostgresSessionManager(db, config)
+	}},
+}
+
+// TestSessionManagerContract runs the same cases against every store, they must behave alike.
+func TestSessionManagerContract(t *testing.T) {
+	cases := []struct {
+		name   string
+		config Config
+		run    func(t *testing.T, manager ISessionManager)
+	}{
+		{"limit queues requests beyond it", Config{Limit: 2}, testSessionLimit},
+		{"waiting tickets are admitted first come first served", Config{Limit: 1}, testFIFOAdmission},
+		{"touching a session slides its expiry", Config{Limit: 1}, testSlidingTTL},
+		{"a user beyond the cap replaces the oldest session", Config{Limit: 2, MaxPerUser: 2}, testPerUserCap},
+		{"sessions are listed oldest first and deleted", Config{Limit: 10}, testListAndDelete},
+		{"revoked tokens are rejected", Config{Limit: 1}, testDenylists},
+	}
+
+	for _, store := range sessionStores {
+		t.Run(store.name, func(t *testing.T) {
+			for _, tc := range cases {
+				t.Run(tc.name, func(t *testing.T) {
+					tc.run(t, store.open(t, tc.config))
+				})
+			}
+		})
+	}
+}
+
+func testSession(token string, userID uint) Session {
+	return Session{Token: token, UserID: userID, IP: "192.0.2.1", UserAgent: "test"}
+}
+
+// mustRegister requests a session and fails unless the token got one.
+func mustRegister(t *testing.T, manager ISessionManager, session Session) {
+	t.Helper()
+	ticket, err := manager.RequestSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket != nil {
+		t.Fatalf("%s was queued at position %d, want a session", session.Token, ticket.Position)
+	}
+}
+
+// mustQueue requests a session and fails unless the token is waiting at the position.
+func mustQueue(t *testing.T, manager ISessionManager, session Session, position int64) *Ticket {
+	t.Helper()
+	ticket, err := manager.RequestSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket == nil {
+		t.Fatalf("%s got a session, want position %d", session.Token, position)
+	}
+	if ticket.Status != TicketWaiting || ticket.Position != position {
+		t.Fatalf("%s is %s at position %d, want waiting at %d", session.Token, ticket.Status, ticket.Position, position)
+	}
+	return ticket
+}
+
+func mustQueueStatus(t *testing.T, manager ISessionManager, ticketID string, status TicketStatus, position int64) {
+	t.Helper()
+	ticket, err := manager.QueueStatus(ticketID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Status != status || ticket.Position != position {
+		t.Fatalf("ticket is %s at position %d, want %s at %d", ticket.Status, ticket.Position, status, position)
+	}
+}
+
+func mustList(t *testing.T, manager ISessionManager, userID uint, tokens ...string) []Session {
+	t.Helper()
+	list, err := manager.ListUserSessions(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, len(list))
+	for i, session := range list {
+		got[i] = session.Token
+	}
+	if fmt.Sprint(got) != fmt.Sprint(tokens) {
+		t.Fatalf("sessions of user %d are %v, want %v", userID, got, tokens)
+	}
+	return list
+}
+
+func mustTouch(t *testing.T, manager ISessionManager, session Session, alive bool) {
+	t.Helper()
+	got, err := manager.TouchSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != alive {
+		t.Fatalf("touching %s reported alive = %v, want %v", session.Token, got, alive)
+	}
+}
+
+func testSessionLimit(t *testing.T, manager ISessionManager) {
+	mustRegister(t, manager, testSession("a", 1))
+	mustRegister(t, manager, testSession("b", 2))
+	// a token with a session keeps it
+	mustRegister(t, manager, testSession("a", 1))
+	mustQueue(t, manager, testSession("c", 3), 1)
+
+	admitted, err := manager.AdmitWaiting()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admitted != 0 {
+		t.Fatalf("admitted %d tickets without a free slot", admitted)
+	}
+}
+
+func testFIFOAdmission(t *testing.T, manager ISessionManager) {
+	first := testSession("a", 1)
+	mustRegister(t, manager, first)
+	second := mustQueue(t, manager, testSession("b", 2), 1)
+	third := mustQueue(t, manager, testSession("c", 3), 2)
+	// retrying keeps the place in the line
+	mustQueue(t, manager, testSession("b", 2), 1)
+
+	if err := manager.DeleteSession(first.Token); err != nil {
+		t.Fatal(err)
+	}
+	// the free slot belongs to the waiting tickets, not to a newcomer
+	fourth := mustQueue(t, manager, testSession("d", 4), 3)
+
+	admitted, err := manager.AdmitWaiting()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admitted != 1 {
+		t.Fatalf("admitted %d tickets into one free slot", admitted)
+	}
+	mustQueueStatus(t, manager, second.ID, TicketAdmitted, 0)
+	mustQueueStatus(t, manager, third.ID, TicketWaiting, 1)
+	mustQueueStatus(t, manager, fourth.ID, TicketWaiting, 2)
+	mustRegister(t, manager, testSession("b", 2))
+	mustQueueStatus(t, manager, "unknown", TicketExpired, 0)
+}
+
+func testSlidingTTL(t *testing.T, manager ISessionManager) {
+	session := testSession("a", 1)
+	mustRegister(t, manager, session)
+	before := mustList(t, manager, 1, "a")[0]
+	if !before.ExpiresAt.After(time.Now().Add(SessionTTL - time.Minute)) {
+		t.Fatalf("session expires at %v, want about %v from now", before.ExpiresAt, SessionTTL)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	session.IP = "192.0.2.2"
+	mustTouch(t, manager, session, true)
+	after := mustList(t, manager, 1, "a")[0]
+	if !after.ExpiresAt.After(before.ExpiresAt) || !after.LastSeenAt.After(before.LastSeenAt) {
+		t.Fatalf("touch moved the expiry from %v to %v", before.ExpiresAt, after.ExpiresAt)
+	}
+	if after.IP != session.IP {
+		t.Fatalf("session IP is %s, want the last one %s", after.IP, session.IP)
+	}
+
+	mustTouch(t, manager, testSession("unknown", 1), false)
+}
+
+func testPerUserCap(t *testing.T, manager ISessionManager) {
+	mustRegister(t, manager, testSession("a", 1))
+	time.Sleep(5 * time.Millisecond)
+	mustRegister(t, manager, testSession("b", 1))
+	time.Sleep(5 * time.Millisecond)
+	// every slot is taken, but replacing a session of the user needs none
+	mustRegister(t, manager, testSession("c", 1))
+
+	mustList(t, manager, 1, "b", "c")
+	mustTouch(t, manager, testSession("a", 1), false)
+	mustQueue(t, manager, testSession("d", 2), 1)
+}
+
+func testListAndDelete(t *testing.T, manager ISessionManager) {
+	for _, session := range []Session{testSession("a", 1), testSession("b", 1), testSession("c", 1), testSession("d", 2)} {
+		mustRegister(t, manager, session)
+		time.Sleep(5 * time.Millisecond)
+	}
+	mustList(t, manager, 1, "a", "b", "c")
+
+	if err := manager.DeleteSession("b"); err != nil {
+		t.Fatal(err)
+	}
+	mustList(t, manager, 1, "a", "c")
+	mustTouch(t, manager, testSession("b", 1), false)
+
+	if err := manager.DeleteUserSessions(1); err != nil {
+		t.Fatal(err)
+	}
+	mustList(t, manager, 1)
+	mustList(t, manager, 2, "d")
+}
+
+func testDenylists(t *testing.T, manager ISessionManager) {
+	mustRevoked := func(jti string, userID uint, issuedAt time.Time, want bool) {
+		t.Helper()
+		revoked, err := manager.IsTokenRevoked(jti, userID, issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != want {
+			t.Fatalf("token %q of user %d issued at %v revoked = %v, want %v", jti, userID, issuedAt, revoked, want)
+		}
+	}
+	issuedAt := time.Now().Add(-time.Minute)
+
+	if err := manager.RevokeToken("revoked", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// an already expired token needs no entry
+	if err := manager.RevokeToken("expired", 0); err != nil {
+		t.Fatal(err)
+	}
+	mustRevoked("revoked", 1, issuedAt, true)
+	mustRevoked("other", 1, issuedAt, false)
+	mustRevoked("expired", 1, issuedAt, false)
+
+	if err := manager.RevokeUserTokens(1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	mustRevoked("other", 1, issuedAt, true)
+	mustRevoked("", 1, issuedAt, true)
+	mustRevoked("other", 2, issuedAt, false)
+	// tokens issued after the logout are accepted
+	mustRevoked("other", 1, time.Now().Add(2*time.Second), false)
+}
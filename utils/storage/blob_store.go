@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"gin-freemarket/utils/env"
+	"os"
+)
+
+// BlobStore stores binary objects such as item images under a slash separated key.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL of the object, it does not check that the object exists.
+	URL(key string) string
+}
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// NewBlobStoreFromEnv creates the blob store selected by BLOB_STORE ("local" by default or "s3").
+//
+// local : BLOB_LOCAL_DIR (default ./uploads), BLOB_PUBLIC_URL (default /uploads)
+// s3    : S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY, S3_SECRET_KEY, S3_PUBLIC_URL
+func NewBlobStoreFromEnv() (BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "local":
+		return NewLocalBlobStore(env.String("BLOB_LOCAL_DIR", "./uploads"), env.String("BLOB_PUBLIC_URL", "/uploads"))
+	case "s3":
+		return NewS3BlobStore(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    env.String("S3_REGION", "us-east-1"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+		})
+	default:
+		return nil, errors.New("unknown BLOB_STORE: " + os.Getenv("BLOB_STORE"))
+	}
+}